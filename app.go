@@ -0,0 +1,148 @@
+package ebase
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// the work dir NewApp changed to, relative paths in config values are
+// against it after a daemon moved to /
+var appWorkDir string
+
+// App options, the zero value searches the default config locations
+type Options struct {
+	ConfigFile string      // config file, empty searches the default list
+	PidFile    string      // pid file, empty uses sys.pid or /var/run/<app>.pid
+	NoPid      bool        // don't write a pid file
+	WorkDir    string      // change to this dir before loading the config
+	Chroot     bool        // chroot to WorkDir
+	Log        *LogOptions // log options, nil reads the log section
+}
+
+// App is an initialized ebase application. Unlike EbaseInit it reports
+// every failure as an error and never exits the process.
+type App struct {
+	Options    *Options
+	Config     *Configuration
+	Log        *BaseLog
+	ConfigFile string
+	PidFile    string
+	Pid        *PidFile
+
+	lock       sync.Mutex
+	components []*appComponent
+}
+
+// NewApp changes dir, loads the config, writes the pid file and opens the
+// log. Errors are ErrConfigNotFound, *ConfigParseError, *PidFileError or
+// *LogOpenError, possibly wrapped.
+func NewApp(opt *Options) (app *App, err error) {
+	if opt == nil {
+		opt = new(Options)
+	}
+	app = &App{Options: opt}
+
+	if opt.WorkDir != "" {
+		if err = syscall.Chdir(opt.WorkDir); err != nil {
+			return nil, &os.PathError{Op: "chdir", Path: opt.WorkDir, Err: err}
+		}
+
+		if opt.Chroot {
+			pwd, _ := os.Getwd()
+			if err = syscall.Chroot(pwd); err != nil {
+				return nil, &os.PathError{Op: "chroot", Path: pwd, Err: err}
+			}
+			chrootDir = pwd
+		}
+	} else if opt.Chroot {
+		return nil, errors.New("chroot requires a work dir")
+	}
+	appWorkDir, _ = os.Getwd()
+
+	app.Config, app.ConfigFile, err = ReadConfig(opt.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opt.NoPid {
+		app.PidFile = opt.PidFile
+		if app.PidFile == "" {
+			app.PidFile, _ = app.Config.String("sys.pid", "")
+		}
+		if app.PidFile == "" {
+			app.PidFile = "/var/run/" + AppName + ".pid"
+		}
+
+		if app.Pid, err = LockPid(app.PidFile); err != nil {
+			return nil, err
+		}
+	}
+
+	logOpt := opt.Log
+	if logOpt == nil {
+		if logOpt, err = configLogOptions(app.Config); err != nil {
+			app.removePid()
+			return nil, err
+		}
+	}
+
+	if app.Log, err = OpenLog(logOpt); err != nil {
+		app.removePid()
+		return nil, err
+	}
+
+	return app, nil
+}
+
+// Close removes the pid file and closes the log output
+func (app *App) Close() error {
+	err := app.removePid()
+
+	if app.Log != nil {
+		if e := app.Log.Close(); err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+func (app *App) removePid() error {
+	err := app.Pid.Remove()
+	app.Pid = nil
+
+	return err
+}
+
+// ReadConfig is LoadConfig returning an error instead of exiting, it also
+// returns the absolute path of the file that was loaded, which stays valid
+// after the daemon changes dir. Includes and the ConfigEnv overlay are
+// merged in.
+func ReadConfig(configFile string) (*Configuration, string, error) {
+	configFile = findConfig(configFile)
+	if configFile == "" {
+		return nil, "", ErrConfigNotFound
+	}
+	if abs, err := filepath.Abs(configFile); err == nil {
+		configFile = abs
+	}
+
+	var files []string
+	sections, err := readConfigFiles(configFile, ConfigEnv(), nil, &files)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, configFile, ErrConfigNotFound
+		}
+		return nil, configFile, &ConfigParseError{File: configFile, Err: err}
+	}
+
+	cfg := NewFileConfiguration(flattenSections(sections))
+	cfg.files = files
+	if cfg.dir = appWorkDir; cfg.dir == "" {
+		cfg.dir, _ = os.Getwd()
+	}
+	return cfg, configFile, nil
+}
@@ -0,0 +1,32 @@
+package ebase
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewAppErrors(t *testing.T) {
+	if _, err := NewApp(&Options{NoPid: true}); !errors.Is(err, ErrConfigNotFound) {
+		t.Fatalf("missing config: got %v, want ErrConfigNotFound", err)
+	}
+
+	dir := t.TempDir()
+	cfg := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(cfg, []byte("[log]\ntype = file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pid := filepath.Join(dir, "app.pid")
+	_, err := NewApp(&Options{ConfigFile: cfg, PidFile: pid,
+		Log: &LogOptions{Type: "file", File: filepath.Join(dir, "no", "such", "app.log")}})
+
+	var logErr *LogOpenError
+	if !errors.As(err, &logErr) {
+		t.Fatalf("bad log file: got %v, want *LogOpenError", err)
+	}
+	if IsExist(pid) {
+		t.Fatal("pid file left behind after failed init")
+	}
+}
@@ -0,0 +1,161 @@
+package ebase
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// BindConfig fills the struct dst points to from the config section using
+// the global Config. See Configuration.Bind.
+func BindConfig(section string, dst interface{}) error {
+	return Config.Bind(section, dst)
+}
+
+// Bind fills the struct dst points to from section. Fields are matched by
+// tags:
+//
+//	Host    string        `cfg:"host" default:"localhost"`
+//	Name    string        `cfg:"name" required:"true"`
+//	Timeout time.Duration `cfg:"timeout" default:"5s"`
+//	Peers   []string      `cfg:"peers"` // comma separated
+//	Redis   RedisOption   `cfg:"redis"` // nested, keys redis.*
+//
+// A field without cfg tag uses its lower cased name, cfg:"-" skips it.
+// Durations also accept a plain number of seconds. Secret references such
+// as env:DB_PASS are resolved for secret keys, see IsSecretKey. Every
+// missing or malformed key is reported in one *ConfigBindError.
+func (c *Configuration) Bind(section string, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("bind config: want pointer to struct, got %T", dst)
+	}
+
+	bindErr := &ConfigBindError{Section: section}
+	c.bindStruct(section, v.Elem(), bindErr)
+	if len(bindErr.Problems) > 0 {
+		return bindErr
+	}
+
+	return nil
+}
+
+func (c *Configuration) bindStruct(prefix string, v reflect.Value, bindErr *ConfigBindError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Tag.Get("cfg")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			c.bindStruct(key, fv, bindErr)
+			continue
+		}
+
+		value, _, ok := c.Lookup(key)
+		if !ok {
+			if field.Tag.Get("required") == "true" {
+				bindErr.Problems = append(bindErr.Problems, "missing "+key)
+				continue
+			}
+			if value, ok = field.Tag.Lookup("default"); !ok {
+				continue
+			}
+		}
+
+		resolved, err := c.resolveKey(key, value)
+		if err != nil {
+			bindErr.Problems = append(bindErr.Problems, fmt.Sprintf("bad %s: %s", key, err))
+			continue
+		}
+		if err := setField(fv, resolved); err != nil {
+			bindErr.Problems = append(bindErr.Problems, fmt.Sprintf("bad %s %q: %s", key, Redact(key, value), err))
+		}
+	}
+}
+
+func setField(fv reflect.Value, value string) error {
+	if fv.Type() == durationType {
+		d, err := parseDuration(value)
+		if err == nil {
+			fv.SetInt(int64(d))
+		}
+		return err
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 0, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("want integer")
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 0, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("want unsigned integer")
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("want number")
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		var parts []string
+		if strings.TrimSpace(value) != "" {
+			parts = strings.Split(value, ",")
+		}
+		s := reflect.MakeSlice(fv.Type(), len(parts), len(parts))
+		for i, p := range parts {
+			if err := setField(s.Index(i), strings.TrimSpace(p)); err != nil {
+				return err
+			}
+		}
+		fv.Set(s)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+
+	return nil
+}
+
+// a Go duration or a number of seconds
+func parseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(n * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("want duration like 30s")
+	}
+	return d, nil
+}
@@ -0,0 +1,86 @@
+package ebase
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBindDefaults(t *testing.T) {
+	cfg := NewConfiguration(NewMapSource(LayerFile, map[string]string{
+		"database.host":  "db1",
+		"database.debug": "yes",
+		"redis.port":     "6380",
+	}))
+
+	opt := new(ModelOption)
+	if err := cfg.Bind("", opt); err != nil {
+		t.Fatal(err)
+	}
+
+	want := ModelOption{
+		Orm: OrmOption{Driver: "postgres", Proto: "tcp", Host: "db1", Schema: "public",
+			Log: "var/database.log", Port: 5432, CacheTime: 300, Debug: true},
+		Redis: RedisOption{Host: "localhost", Prefix: "ebase", Port: 6380},
+	}
+	if *opt != want {
+		t.Errorf("got %+v\nwant %+v", *opt, want)
+	}
+}
+
+func TestBindTypes(t *testing.T) {
+	var dst struct {
+		Timeout time.Duration `cfg:"timeout" default:"5s"`
+		Idle    time.Duration `cfg:"idle"`
+		Peers   []string      `cfg:"peers"`
+		Ports   []int         `cfg:"ports"`
+		Ratio   float64
+		Skip    string `cfg:"-"`
+	}
+	cfg := NewConfiguration(NewMapSource(LayerFile, map[string]string{
+		"srv.idle":  "90",
+		"srv.peers": "a, b,c",
+		"srv.ports": "80,443",
+		"srv.ratio": "0.5",
+		"srv.skip":  "x",
+	}))
+
+	if err := cfg.Bind("srv", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Timeout != 5*time.Second || dst.Idle != 90*time.Second {
+		t.Errorf("durations %s %s", dst.Timeout, dst.Idle)
+	}
+	if !reflect.DeepEqual(dst.Peers, []string{"a", "b", "c"}) || !reflect.DeepEqual(dst.Ports, []int{80, 443}) {
+		t.Errorf("slices %q %v", dst.Peers, dst.Ports)
+	}
+	if dst.Ratio != 0.5 || dst.Skip != "" {
+		t.Errorf("ratio %v skip %q", dst.Ratio, dst.Skip)
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	var dst struct {
+		Name string `cfg:"name" required:"true"`
+		Port int    `cfg:"port"`
+		TLS  bool   `cfg:"tls"`
+	}
+	cfg := NewConfiguration(NewMapSource(LayerFile, map[string]string{
+		"srv.port": "http",
+		"srv.tls":  "maybe",
+	}))
+
+	err := cfg.Bind("srv", &dst)
+	var bindErr *ConfigBindError
+	if !errors.As(err, &bindErr) {
+		t.Fatalf("err = %v, want *ConfigBindError", err)
+	}
+	if len(bindErr.Problems) != 3 {
+		t.Errorf("problems %q, want 3", bindErr.Problems)
+	}
+
+	if err := cfg.Bind("srv", dst); err == nil {
+		t.Error("non pointer accepted")
+	}
+}
@@ -0,0 +1,240 @@
+package ebase
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// build information, set at link time:
+//
+//	go build -ldflags "-X github.com/forease/ebase.Version=1.2.0 -X github.com/forease/ebase.BuildTime=`date +%F`"
+var (
+	Version   = "dev"
+	BuildTime = ""
+)
+
+// the command line of the process: Flags and the registered commands
+var cmdline = newCommandLine(AppName, flag.CommandLine)
+
+// Flags holds the ebase and application flags. Apps add their own before
+// EbaseInit, e.g. ebase.Flags.String("listen", ":80", "Listen address").
+// Flags defined on flag.CommandLine are parsed too.
+var Flags = cmdline.flags
+
+// a subcommand such as "status" or "check-config"
+type Command struct {
+	Name  string
+	Usage string        // one line description for the help text
+	Flags *flag.FlagSet // command flags, may be nil
+
+	// Run executes the command and the process exits with its result,
+	// an *ExitCodeError sets the exit code. A nil Run continues the normal
+	// startup.
+	Run func(args []string) error
+
+	// continue the normal startup after Run succeeded, like "restart"
+	Continue bool
+}
+
+// flags and subcommands parsed from the arguments
+type commandLine struct {
+	flags    *flag.FlagSet
+	global   *flag.FlagSet // app flags parsed too, may be nil
+	commands map[string]*Command
+	args     bool // positional arguments other than commands allowed
+}
+
+func newCommandLine(name string, global *flag.FlagSet) *commandLine {
+	c := &commandLine{flags: flag.NewFlagSet(name, flag.ContinueOnError), global: global,
+		commands: make(map[string]*Command)}
+	c.flags.SetOutput(io.Discard)
+	return c
+}
+
+// RegisterCommand adds a subcommand, a command with the same name is
+// replaced
+func RegisterCommand(cmd *Command) {
+	cmdline.commands[cmd.Name] = cmd
+}
+
+func init() {
+	RegisterCommand(&Command{Name: "version", Usage: "Show version and exit",
+		Run: func(args []string) error {
+			fmt.Println(VersionString())
+			return nil
+		}})
+	RegisterCommand(&Command{Name: "help", Usage: "Show this help and exit",
+		Run: func(args []string) error {
+			WriteHelp(os.Stdout)
+			return nil
+		}})
+}
+
+func VersionString() string {
+	if BuildTime != "" {
+		return fmt.Sprintf("%s version %s (built %s)", AppName, Version, BuildTime)
+	}
+	return fmt.Sprintf("%s version %s", AppName, Version)
+}
+
+// AllowArgs lets the app take positional arguments of its own, left in
+// Flags.Args(). Without it an unknown command is an error, so a mistyped
+// "stpo" does not start the daemon.
+func AllowArgs() {
+	cmdline.args = true
+}
+
+// ParseFlags parses args into Flags and returns the subcommand named by the
+// first positional argument, if any, with its remaining arguments. An
+// unknown positional argument is an error unless AllowArgs was called.
+func ParseFlags(args []string) (cmd *Command, cmdArgs []string, err error) {
+	return cmdline.parse(args)
+}
+
+func (c *commandLine) parse(args []string) (cmd *Command, cmdArgs []string, err error) {
+	// pick up flags defined by the app on the global flag set
+	if c.global != nil {
+		c.global.VisitAll(func(f *flag.Flag) {
+			if c.flags.Lookup(f.Name) == nil {
+				c.flags.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+	}
+
+	if err = c.flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	rest := c.flags.Args()
+	// keep flag.Args() working for apps
+	if c.global != nil {
+		c.global.Parse(append([]string{"--"}, rest...))
+	}
+
+	if len(rest) == 0 {
+		return nil, nil, nil
+	}
+
+	cmd, ok := c.commands[rest[0]]
+	if !ok {
+		if c.args {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("unknown command %q", rest[0])
+	}
+
+	cmdArgs = rest[1:]
+	if cmd.Flags != nil {
+		cmd.Flags.SetOutput(io.Discard)
+		if err = cmd.Flags.Parse(cmdArgs); err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", cmd.Name, err)
+		}
+		cmdArgs = cmd.Flags.Args()
+	}
+
+	return cmd, cmdArgs, nil
+}
+
+// parse the command line for EbaseInit, it only returns when the normal
+// startup should continue
+func handleCommandLine() {
+	cmd, args, err := ParseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		Help()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n", err)
+		WriteHelp(os.Stderr)
+		os.Exit(2)
+	}
+
+	if *help {
+		Help()
+	}
+
+	if *verbose {
+		fmt.Println(VersionString())
+		os.Exit(0)
+	}
+
+	if cmd == nil || cmd.Run == nil {
+		return
+	}
+
+	err = cmd.Run(args)
+	if err == nil && cmd.Continue {
+		return
+	}
+
+	var exitErr *ExitCodeError
+	if errors.As(err, &exitErr) {
+		if exitErr.Err != nil {
+			fmt.Fprintln(os.Stderr, exitErr.Err)
+		}
+		os.Exit(exitErr.Code)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitFailure)
+	}
+	os.Exit(ExitOk)
+}
+
+// write the usage text generated from Flags and the commands to w
+func WriteHelp(w io.Writer) {
+	cmdline.writeHelp(w)
+}
+
+func (c *commandLine) writeHelp(w io.Writer) {
+	fmt.Fprintf(w, "\nUseage: %s [ Options ] [ Command ]\n\nOptions:\n", os.Args[0])
+	writeFlags(w, c.flags, "  ")
+
+	if len(c.commands) > 0 {
+		names := make([]string, 0, len(c.commands))
+		width := 0
+		for name := range c.commands {
+			names = append(names, name)
+			if len(name) > width {
+				width = len(name)
+			}
+		}
+		sort.Strings(names)
+
+		fmt.Fprint(w, "\nCommands:\n")
+		for _, name := range names {
+			fmt.Fprintf(w, "  %-*s  %s\n", width, name, c.commands[name].Usage)
+			if fs := c.commands[name].Flags; fs != nil {
+				writeFlags(w, fs, "    ")
+			}
+		}
+	}
+
+	fmt.Fprint(w, "\n"+
+		"------------------------------------------------------\n\n"+
+		"  Author:  16hot (im16hot@gmail.com) \n"+
+		"  Company: Beijing ForEase Times Technology Co., Ltd.\n"+
+		"  Website: http://www.forease.net\n"+
+		"  MyBlog:  http://16hot.com\n"+
+		"  Version: "+Version+"\n\n"+
+		"------------------------------------------------------\n\n")
+}
+
+func writeFlags(w io.Writer, fs *flag.FlagSet, indent string) {
+	fs.VisitAll(func(f *flag.Flag) {
+		name, usage := flag.UnquoteUsage(f)
+		line := indent + "-" + f.Name
+		if name != "" {
+			line += " " + name
+		}
+		line += "\n" + indent + "    " + strings.ReplaceAll(usage, "\n", "\n"+indent+"    ")
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" {
+			line += fmt.Sprintf(" [Default: %s]", f.DefValue)
+		}
+		fmt.Fprintln(w, line)
+	})
+}
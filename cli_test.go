@@ -0,0 +1,68 @@
+package ebase
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	global := flag.NewFlagSet("app", flag.ContinueOnError)
+	listen := global.String("listen", ":80", "Listen `address`")
+	c := newCommandLine("app", global)
+	config := c.flags.String("c", "", "Server `config` file")
+
+	fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+	wait := fs.Int("wait", 10, "Seconds to wait")
+	c.commands["stop"] = &Command{Name: "stop", Usage: "Stop the daemon", Flags: fs,
+		Run: func(args []string) error { return nil }}
+
+	cmd, args, err := c.parse([]string{"-c", "app.conf", "-listen", ":8080", "stop", "-wait", "3", "now"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.Name != "stop" {
+		t.Fatalf("command %v, want stop", cmd)
+	}
+	if *config != "app.conf" || *listen != ":8080" || *wait != 3 {
+		t.Fatalf("flags c=%s listen=%s wait=%d", *config, *listen, *wait)
+	}
+	if len(args) != 1 || args[0] != "now" {
+		t.Fatalf("command args %v", args)
+	}
+	if global.NArg() != 4 || global.Arg(0) != "stop" {
+		t.Fatalf("global args %v", global.Args())
+	}
+
+	var buf bytes.Buffer
+	c.writeHelp(&buf)
+	for _, s := range []string{"-c config", "-listen address", "stop", "-wait"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Fatalf("help text missing %q:\n%s", s, buf.String())
+		}
+	}
+}
+
+func TestParseFlagsUnknown(t *testing.T) {
+	c := newCommandLine("app", nil)
+	c.flags.Bool("v", false, "Show version")
+
+	// a mistyped command does not start the program
+	if _, _, err := c.parse([]string{"-v", "stpo"}); err == nil || !strings.Contains(err.Error(), `unknown command "stpo"`) {
+		t.Fatalf("unknown command: %v", err)
+	}
+
+	// unless the app takes arguments of its own
+	c.args = true
+	cmd, _, err := c.parse([]string{"-v", "serve"})
+	if err != nil || cmd != nil {
+		t.Fatalf("command %v, error %v", cmd, err)
+	}
+	if c.flags.Arg(0) != "serve" {
+		t.Fatalf("args %v", c.flags.Args())
+	}
+	if _, _, err = c.parse([]string{"-x"}); err == nil {
+		t.Fatal("unknown flag accepted")
+	}
+}
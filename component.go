@@ -0,0 +1,307 @@
+package ebase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Component is a part of an App, like the database or the mail sender.
+// Start returns once the component runs, the ctx given to it is canceled
+// when the app stops. Stop is called in reverse start order on shutdown.
+type Component interface {
+	Starter
+	Stopper
+}
+
+// a Component that can fail after Start. Wait returns when the component
+// stopped running, an error applies the component policy.
+type Waiter interface {
+	Wait() error
+}
+
+// what the app does when a component fails to start or fails later
+const (
+	ComponentFailFast = iota // stop the app
+	ComponentRestart         // restart the component with backoff
+)
+
+type ComponentOptions struct {
+	DependsOn   []string      // components started before this one
+	Policy      int           // ComponentFailFast or ComponentRestart
+	MaxRestarts int           // restarts allowed, 0 unlimited
+	MinBackoff  time.Duration // first restart delay, default 1s
+	MaxBackoff  time.Duration // restart delay cap, default 1m
+}
+
+type appComponent struct {
+	name string
+	c    Component
+	opt  ComponentOptions
+
+	lock     sync.Mutex // held while starting or stopping
+	running  bool
+	restarts int
+}
+
+// the App set up by EbaseInit
+var MainApp *App
+
+// AddComponent adds a component to MainApp, see App.Add
+func AddComponent(name string, c Component, opt *ComponentOptions) error {
+	return MainApp.Add(name, c, opt)
+}
+
+// Run runs the components of MainApp until shutdown and exits the process.
+// A component failing with ComponentFailFast exits with ExitFailure. With
+// sys.supervise it first becomes the supervisor unless EbaseInit did.
+//
+//	ebase.EbaseInit()
+//	dbh, err := ebase.NewDefaultModels()
+//	...
+//	ebase.AddComponent("database", dbh, nil)
+//	ebase.AddComponent("mail", ebase.NewSmtp(), &ebase.ComponentOptions{Policy: ebase.ComponentRestart})
+//	ebase.Run()
+func Run() {
+	if supervisePending {
+		Supervise(nil)
+	}
+
+	err := MainApp.Run(context.Background())
+	if Shutdowner.Running() {
+		// stopped by a signal, Shutdown exits when its hooks are done
+		select {}
+	}
+
+	if err != nil {
+		logInfof("%s", err)
+		Shutdown(ExitFailure)
+	}
+	Shutdown(ExitOk)
+}
+
+// Add registers a component, started by Run after the components it
+// depends on. opt may be nil for a fail-fast component without
+// dependencies.
+func (app *App) Add(name string, c Component, opt *ComponentOptions) error {
+	app.lock.Lock()
+	defer app.lock.Unlock()
+
+	for _, ac := range app.components {
+		if ac.name == name {
+			return fmt.Errorf("component %s already added", name)
+		}
+	}
+
+	ac := &appComponent{name: name, c: c}
+	if opt != nil {
+		ac.opt = *opt
+	}
+	if ac.opt.MinBackoff <= 0 {
+		ac.opt.MinBackoff = time.Second
+	}
+	if ac.opt.MaxBackoff < ac.opt.MinBackoff {
+		ac.opt.MaxBackoff = time.Minute
+	}
+	app.components = append(app.components, ac)
+
+	return nil
+}
+
+// Run starts the components in dependency order, reports Ready and blocks
+// until ctx is done, shutdown starts or a fail-fast component fails. The
+// started components are then stopped in reverse order within the
+// shutdown timeout.
+func (app *App) Run(ctx context.Context) error {
+	app.lock.Lock()
+	order, err := componentOrder(app.components)
+	app.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	RegisterShutdown("app", ShutdownFirst, func(sctx context.Context) error {
+		cancel()
+		select {
+		case <-stopped:
+			return nil
+		case <-sctx.Done():
+			return sctx.Err()
+		}
+	})
+
+	failed := make(chan error, 1)
+	var started []*appComponent
+	for _, ac := range order {
+		if err = app.start(ctx, ac); err != nil {
+			break
+		}
+		started = append(started, ac)
+		if w, ok := ac.c.(Waiter); ok {
+			go app.watch(ctx, ac, w, failed)
+		}
+	}
+
+	if err != nil {
+		DaemonFailed(err)
+	} else {
+		logInfof("app started %d components", len(started))
+		Ready()
+		select {
+		case <-ctx.Done():
+		case err = <-failed:
+		}
+	}
+	cancel()
+
+	timeout := Shutdowner.Timeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	sctx, scancel := context.WithTimeout(context.Background(), timeout)
+	defer scancel()
+
+	for i := len(started) - 1; i >= 0; i-- {
+		started[i].stop(sctx)
+	}
+	close(stopped)
+
+	return err
+}
+
+// start ac, retrying with backoff under ComponentRestart
+func (app *App) start(ctx context.Context, ac *appComponent) error {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	backoff := ac.opt.MinBackoff
+	for {
+		err := ac.c.Start(ctx)
+		if err == nil {
+			ac.running = true
+			logInfof("component %s started", ac.name)
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if ac.opt.Policy != ComponentRestart {
+			return fmt.Errorf("component %s: start: %s", ac.name, err)
+		}
+		if ac.opt.MaxRestarts > 0 && ac.restarts >= ac.opt.MaxRestarts {
+			return fmt.Errorf("component %s: start: %s, gave up after %d restarts", ac.name, err, ac.restarts)
+		}
+
+		ac.restarts++
+		logInfof("component %s start failed: %s, retrying in %s", ac.name, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > ac.opt.MaxBackoff {
+			backoff = ac.opt.MaxBackoff
+		}
+	}
+}
+
+func (ac *appComponent) stop(ctx context.Context) {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	if !ac.running {
+		return
+	}
+	ac.running = false
+
+	start := time.Now()
+	if err := ac.c.Stop(ctx); err != nil {
+		logInfof("component %s stop failed after %s: %s", ac.name, time.Since(start), err)
+	} else {
+		logInfof("component %s stopped in %s", ac.name, time.Since(start))
+	}
+}
+
+// apply the policy whenever Wait reports a failure
+func (app *App) watch(ctx context.Context, ac *appComponent, w Waiter, failed chan<- error) {
+	for {
+		err := w.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			logInfof("component %s finished", ac.name)
+			return
+		}
+
+		logInfof("component %s failed: %s", ac.name, err)
+		if ac.opt.Policy == ComponentRestart {
+			ac.stop(ctx)
+			if err = app.start(ctx, ac); err == nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		} else {
+			err = fmt.Errorf("component %s: %s", ac.name, err)
+		}
+
+		select {
+		case failed <- err:
+		default:
+		}
+		return
+	}
+}
+
+// order components after their dependencies, otherwise in the order they
+// were added
+func componentOrder(components []*appComponent) ([]*appComponent, error) {
+	byName := make(map[string]*appComponent, len(components))
+	for _, ac := range components {
+		byName[ac.name] = ac
+	}
+
+	var order []*appComponent
+	state := make(map[string]int) // 1 visiting, 2 done
+	var visit func(ac *appComponent, path []string) error
+	visit = func(ac *appComponent, path []string) error {
+		switch state[ac.name] {
+		case 1:
+			return fmt.Errorf("component dependency cycle: %s", strings.Join(append(path, ac.name), " -> "))
+		case 2:
+			return nil
+		}
+
+		state[ac.name] = 1
+		for _, dep := range ac.opt.DependsOn {
+			d, ok := byName[dep]
+			if !ok {
+				return fmt.Errorf("component %s: unknown dependency %s", ac.name, dep)
+			}
+			if err := visit(d, append(path, ac.name)); err != nil {
+				return err
+			}
+		}
+		state[ac.name] = 2
+		order = append(order, ac)
+
+		return nil
+	}
+
+	for _, ac := range components {
+		if err := visit(ac, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
@@ -0,0 +1,107 @@
+package ebase
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testComponent struct {
+	name  string
+	lock  *sync.Mutex
+	log   *[]string
+	fails int // Start failures before success
+	exit  chan error
+}
+
+func (c *testComponent) record(s string) {
+	c.lock.Lock()
+	*c.log = append(*c.log, s)
+	c.lock.Unlock()
+}
+
+func (c *testComponent) Start(ctx context.Context) error {
+	if c.fails > 0 {
+		c.fails--
+		c.record("fail " + c.name)
+		return errors.New("not ready")
+	}
+	c.record("start " + c.name)
+	return nil
+}
+
+func (c *testComponent) Stop(ctx context.Context) error {
+	c.record("stop " + c.name)
+	return nil
+}
+
+type waitComponent struct {
+	testComponent
+}
+
+func (c *waitComponent) Wait() error {
+	return <-c.exit
+}
+
+func TestAppRun(t *testing.T) {
+	var lock sync.Mutex
+	var log []string
+	newComponent := func(name string) *testComponent {
+		return &testComponent{name: name, lock: &lock, log: &log}
+	}
+
+	app := new(App)
+	app.Add("api", newComponent("api"), &ComponentOptions{DependsOn: []string{"db", "mail"}})
+	app.Add("mail", newComponent("mail"), &ComponentOptions{DependsOn: []string{"db"}})
+	db := newComponent("db")
+	db.fails = 1
+	app.Add("db", db, &ComponentOptions{Policy: ComponentRestart, MinBackoff: time.Millisecond})
+	if err := app.Add("db", db, nil); err == nil {
+		t.Error("duplicate component added")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := app.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "fail db,start db,start mail,start api,stop api,stop mail,stop db"
+	if got := strings.Join(log, ","); got != want {
+		t.Errorf("run %s, want %s", got, want)
+	}
+}
+
+func TestAppFailFast(t *testing.T) {
+	var lock sync.Mutex
+	var log []string
+
+	app := new(App)
+	app.Add("db", &testComponent{name: "db", lock: &lock, log: &log}, nil)
+	worker := &waitComponent{testComponent{name: "worker", lock: &lock, log: &log, exit: make(chan error)}}
+	app.Add("worker", worker, &ComponentOptions{DependsOn: []string{"db"}})
+
+	go func() { worker.exit <- errors.New("crashed") }()
+	err := app.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "crashed") {
+		t.Errorf("err = %v", err)
+	}
+
+	want := "start db,start worker,stop worker,stop db"
+	if got := strings.Join(log, ","); got != want {
+		t.Errorf("run %s, want %s", got, want)
+	}
+}
+
+func TestComponentOrderCycle(t *testing.T) {
+	app := new(App)
+	app.Add("a", nil, &ComponentOptions{DependsOn: []string{"b"}})
+	app.Add("b", nil, &ComponentOptions{DependsOn: []string{"a"}})
+
+	if err := app.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("err = %v", err)
+	}
+}
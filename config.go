@@ -0,0 +1,455 @@
+package ebase
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// layer names, in rising precedence
+const (
+	LayerFile = "file"
+	LayerEnv  = "env"
+	LayerFlag = "flag"
+)
+
+// ConfigSource is one layer of a Configuration. Keys are "section.key".
+type ConfigSource interface {
+	Name() string
+	Get(key string) (string, bool)
+	Keys() []string
+}
+
+// config values held in a map
+type MapSource struct {
+	name   string
+	values map[string]string
+}
+
+func NewMapSource(name string, values map[string]string) *MapSource {
+	if values == nil {
+		values = make(map[string]string)
+	}
+	return &MapSource{name: name, values: values}
+}
+
+func (m *MapSource) Name() string { return m.name }
+
+func (m *MapSource) Get(key string) (string, bool) {
+	v, ok := m.values[key]
+	return v, ok
+}
+
+func (m *MapSource) Keys() []string {
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// EnvSource maps database.host to <PREFIX>_DATABASE_HOST
+type EnvSource struct {
+	Prefix string
+}
+
+// environment variable prefix, the upper cased app name by default
+var EnvPrefix = envName(AppName)
+
+func (e *EnvSource) Name() string { return LayerEnv }
+
+func (e *EnvSource) Get(key string) (string, bool) {
+	return os.LookupEnv(e.Prefix + "_" + envName(key))
+}
+
+// Keys returns the key of each prefixed variable, see MatchKeys
+func (e *EnvSource) Keys() []string {
+	return e.MatchKeys(nil)
+}
+
+// MatchKeys returns the key of each prefixed variable. As LOG_MAX_SIZE may
+// name log.max_size or log_max.size, a variable is matched against known
+// and the declared config keys first; one matching none is split at its
+// first underscore.
+func (e *EnvSource) MatchKeys(known []string) []string {
+	names := make(map[string]string)
+	for _, key := range known {
+		names[envName(key)] = key
+	}
+	for key := range configSchema {
+		if !strings.Contains(key, "*") {
+			names[envName(key)] = key
+		}
+	}
+
+	var keys []string
+	prefix := e.Prefix + "_"
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, prefix) {
+			continue
+		}
+		name := kv[len(prefix):strings.IndexByte(kv, '=')]
+		if key, ok := names[name]; ok {
+			keys = append(keys, key)
+			continue
+		}
+		name = strings.ToLower(name)
+		if i := strings.IndexByte(name, '_'); i > 0 {
+			name = name[:i] + "." + name[i+1:]
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
+
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// a layer mapping its names to keys given the keys known elsewhere
+type keyMatcher interface {
+	MatchKeys(known []string) []string
+}
+
+// Configuration is a layered view of the config: values set with --set
+// override environment variables, which override the config file. It
+// replaces the config.Config of github.com/forease/config and keeps its
+// String, Int and Bool lookups; like Int64 and Float64 they take a
+// "section.key" and a default, and return the default with an error for
+// a bad value.
+type Configuration struct {
+	lock   sync.RWMutex
+	layers []ConfigSource // lowest precedence first
+	files  []string       // config files read by ReadConfig
+	dir    string         // work dir at load, relative secret files are against it
+
+	// master key of enc: values, read once from keyFile
+	keyLock sync.Mutex
+	keyFile string
+	key     []byte
+}
+
+func NewConfiguration(layers ...ConfigSource) *Configuration {
+	return &Configuration{layers: layers}
+}
+
+// SetLayer replaces the layer with the same name or adds it with the
+// highest precedence
+func (c *Configuration) SetLayer(src ConfigSource) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for i, l := range c.layers {
+		if l.Name() == src.Name() {
+			c.layers[i] = src
+			return
+		}
+	}
+	c.layers = append(c.layers, src)
+}
+
+// Replace takes the layers of src, readers see either the old or the new
+// values. A config reload replaces the global Config this way.
+func (c *Configuration) Replace(src *Configuration) {
+	src.lock.RLock()
+	layers := append([]ConfigSource(nil), src.layers...)
+	files, dir := src.files, src.dir
+	src.lock.RUnlock()
+
+	c.lock.Lock()
+	c.layers = layers
+	c.files = files
+	c.dir = dir
+	c.lock.Unlock()
+
+	// a reload reads the master key again
+	c.keyLock.Lock()
+	c.key = nil
+	c.keyLock.Unlock()
+}
+
+// Files returns the config files the values were read from, with includes
+// and the overlay
+func (c *Configuration) Files() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.files
+}
+
+// Layer returns the layer by name
+func (c *Configuration) Layer(name string) ConfigSource {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for _, l := range c.layers {
+		if l.Name() == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// Lookup returns the value of key and the name of the layer supplying it
+func (c *Configuration) Lookup(key string) (value, layer string, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for i := len(c.layers) - 1; i >= 0; i-- {
+		if v, ok := c.layers[i].Get(key); ok {
+			return v, c.layers[i].Name(), true
+		}
+	}
+	return "", "", false
+}
+
+// Source returns the name of the layer supplying key, "" if unset
+func (c *Configuration) Source(key string) string {
+	_, layer, _ := c.Lookup(key)
+	return layer
+}
+
+// Keys returns every key set in any layer, sorted
+func (c *Configuration) Keys() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(ks []string) {
+		for _, k := range ks {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	// layers guessing their keys, like the environment, match them against
+	// the keys of the others
+	var matchers []keyMatcher
+	for _, l := range c.layers {
+		if m, ok := l.(keyMatcher); ok {
+			matchers = append(matchers, m)
+		} else {
+			add(l.Keys())
+		}
+	}
+	known := append([]string(nil), keys...)
+	for _, m := range matchers {
+		add(m.MatchKeys(known))
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Sections returns the effective values grouped by section
+func (c *Configuration) Sections() map[string]ConfigSection {
+	sections := make(map[string]ConfigSection)
+	for _, key := range c.Keys() {
+		section, name := "", key
+		if i := strings.IndexByte(key, '.'); i >= 0 {
+			section, name = key[:i], key[i+1:]
+		}
+		if sections[section] == nil {
+			sections[section] = ConfigSection{}
+		}
+		// raw values, secret references are not resolved
+		sections[section][name], _, _ = c.Lookup(key)
+	}
+
+	return sections
+}
+
+// the value of key, a secret reference resolved when key names a secret
+func (c *Configuration) value(key string) (string, bool, error) {
+	v, _, ok := c.Lookup(key)
+	if !ok {
+		return "", false, nil
+	}
+
+	v, err := c.resolveKey(key, v)
+	if err != nil {
+		return "", true, fmt.Errorf("config %s: %s", key, err)
+	}
+	return v, true, nil
+}
+
+func (c *Configuration) String(key, def string) (string, error) {
+	v, ok, err := c.value(key)
+	if !ok || err != nil {
+		return def, err
+	}
+	return v, nil
+}
+
+func (c *Configuration) Int(key string, def int) (int, error) {
+	v, ok, err := c.value(key)
+	if !ok || err != nil || v == "" {
+		return def, err
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("config %s: bad int %q", key, Redact(key, v))
+	}
+	return i, nil
+}
+
+func (c *Configuration) Int64(key string, def int64) (int64, error) {
+	v, ok, err := c.value(key)
+	if !ok || err != nil || v == "" {
+		return def, err
+	}
+
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return def, fmt.Errorf("config %s: bad int %q", key, Redact(key, v))
+	}
+	return i, nil
+}
+
+func (c *Configuration) Float64(key string, def float64) (float64, error) {
+	v, ok, err := c.value(key)
+	if !ok || err != nil || v == "" {
+		return def, err
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def, fmt.Errorf("config %s: bad float %q", key, Redact(key, v))
+	}
+	return f, nil
+}
+
+func (c *Configuration) Bool(key string, def bool) (bool, error) {
+	v, ok, err := c.value(key)
+	if !ok || err != nil || v == "" {
+		return def, err
+	}
+
+	b, err := parseBool(v)
+	if err != nil {
+		return def, fmt.Errorf("config %s: bad bool %q", key, Redact(key, v))
+	}
+	return b, nil
+}
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "t", "true", "y", "yes", "on":
+		return true, nil
+	case "0", "f", "false", "n", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("want true or false")
+}
+
+// values given with --set section.key=value
+type configFlags map[string]string
+
+func (f configFlags) String() string {
+	var s []string
+	for k, v := range f {
+		s = append(s, k+"="+v)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (f configFlags) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 1 {
+		return fmt.Errorf("want section.key=value, got %q", s)
+	}
+	f[strings.TrimSpace(s[:i])] = strings.TrimSpace(s[i+1:])
+	return nil
+}
+
+var configOverrides = make(configFlags)
+
+func init() {
+	Flags.Var(configOverrides, "set", "Override a config `key=value`, e.g. database.host=db1, may repeat")
+}
+
+// NewFileConfiguration layers the env and --set values over the file
+// values
+func NewFileConfiguration(file map[string]string) *Configuration {
+	flags := make(map[string]string, len(configOverrides))
+	for k, v := range configOverrides {
+		flags[k] = v
+	}
+
+	return NewConfiguration(
+		NewMapSource(LayerFile, file),
+		&EnvSource{Prefix: EnvPrefix},
+		NewMapSource(LayerFlag, flags),
+	)
+}
+
+// flatten sections into section.key values
+func flattenSections(sections map[string]ConfigSection) map[string]string {
+	values := make(map[string]string)
+	for section, kv := range sections {
+		for k, v := range kv {
+			if section == "" {
+				values[k] = v
+			} else {
+				values[section+"."+k] = v
+			}
+		}
+	}
+	return values
+}
+
+// parse an ini style config. Keys before the first section header go to
+// the "" section.
+func parseIni(r io.Reader) (map[string]ConfigSection, error) {
+	sections := map[string]ConfigSection{"": {}}
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("line %d: bad section %q", n, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := sections[section]; !ok {
+				sections[section] = ConfigSection{}
+			}
+			continue
+		}
+
+		i := strings.IndexAny(line, "=:")
+		if i < 1 {
+			return nil, fmt.Errorf("line %d: bad line %q", n, line)
+		}
+
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) > 1 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		sections[section][strings.TrimSpace(line[:i])] = value
+	}
+
+	return sections, scanner.Err()
+}
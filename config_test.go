@@ -0,0 +1,87 @@
+package ebase
+
+import (
+	"testing"
+)
+
+func TestConfigurationLayers(t *testing.T) {
+	t.Setenv("TESTAPP_DATABASE_HOST", "env-host")
+	t.Setenv("TESTAPP_DATABASE_PORT", "6432")
+
+	cfg := NewConfiguration(
+		NewMapSource(LayerFile, map[string]string{
+			"database.host": "file-host",
+			"database.port": "5432",
+			"database.user": "ebase",
+			"log.level":     "3",
+		}),
+		&EnvSource{Prefix: "TESTAPP"},
+		NewMapSource(LayerFlag, map[string]string{"database.host": "flag-host"}),
+	)
+
+	tests := []struct {
+		key, value, layer string
+	}{
+		{"database.host", "flag-host", LayerFlag},
+		{"database.port", "6432", LayerEnv},
+		{"database.user", "ebase", LayerFile},
+	}
+	for _, tt := range tests {
+		v, layer, ok := cfg.Lookup(tt.key)
+		if !ok || v != tt.value || layer != tt.layer {
+			t.Errorf("%s = %q from %q, want %q from %q", tt.key, v, layer, tt.value, tt.layer)
+		}
+	}
+
+	if port, err := cfg.Int("database.port", 0); err != nil || port != 6432 {
+		t.Errorf("Int database.port = %d, %v", port, err)
+	}
+	if name, _ := cfg.String("database.name", "def"); name != "def" {
+		t.Errorf("missing key gave %q, want default", name)
+	}
+	if cfg.Source("database.name") != "" {
+		t.Errorf("missing key has a source")
+	}
+
+	if s := cfg.Sections()["database"]; s["host"] != "flag-host" || s["port"] != "6432" {
+		t.Errorf("database section %v", s)
+	}
+}
+
+func TestEnvSourceKeys(t *testing.T) {
+	t.Setenv("TESTAPP_SMTP_RELAY_HOST", "mx")
+	t.Setenv("TESTAPP_EXTRA_OPT_X", "1")
+
+	cfg := NewConfiguration(
+		NewMapSource(LayerFile, map[string]string{"smtp_relay.host": "localhost"}),
+		&EnvSource{Prefix: "TESTAPP"},
+	)
+
+	// the env variable names the file key, an unknown one splits at the
+	// first underscore
+	keys := cfg.Keys()
+	if len(keys) != 2 || keys[0] != "extra.opt_x" || keys[1] != "smtp_relay.host" {
+		t.Fatalf("keys %v", keys)
+	}
+	if v, layer, _ := cfg.Lookup("smtp_relay.host"); v != "mx" || layer != LayerEnv {
+		t.Errorf("smtp_relay.host = %q from %s", v, layer)
+	}
+}
+
+func TestConfigurationNumbers(t *testing.T) {
+	cfg := NewConfiguration(NewMapSource(LayerFile, map[string]string{
+		"cache.size": "8589934592", "cache.ratio": "0.75", "cache.bad": "x"}))
+
+	if n, err := cfg.Int64("cache.size", 0); err != nil || n != 8<<30 {
+		t.Errorf("Int64 = %d, %v", n, err)
+	}
+	if f, err := cfg.Float64("cache.ratio", 0); err != nil || f != 0.75 {
+		t.Errorf("Float64 = %v, %v", f, err)
+	}
+	if f, err := cfg.Float64("cache.bad", 1.5); err == nil || f != 1.5 {
+		t.Errorf("bad Float64 = %v, %v", f, err)
+	}
+	if n, err := cfg.Int64("cache.none", 7); err != nil || n != 7 {
+		t.Errorf("missing Int64 = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,164 @@
+package ebase
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigParser parses a config file into sections. Nested keys end up in
+// the section of their top level key, e.g. database.replica.host is key
+// "replica.host" of section "database".
+type ConfigParser func(r io.Reader) (map[string]ConfigSection, error)
+
+// config parsers by file extension
+var configFormats = map[string]ConfigParser{
+	".conf": parseIni,
+	".ini":  parseIni,
+	".yaml": parseYAML,
+	".yml":  parseYAML,
+	".toml": parseTOML,
+	".json": parseJSON,
+}
+
+// extensions tried, in order, when searching for <app>.<ext>
+var ConfigExtensions = []string{".conf", ".yaml", ".yml", ".toml", ".json"}
+
+// RegisterConfigFormat adds or replaces the parser for files ending in ext
+func RegisterConfigFormat(ext string, parser ConfigParser) {
+	configFormats[strings.ToLower(ext)] = parser
+}
+
+// ReadConfigSections reads a config file into sections, the format is
+// chosen by the file extension. Unknown extensions are read as ini.
+func ReadConfigSections(file string) (map[string]ConfigSection, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	parser, ok := configFormats[strings.ToLower(filepath.Ext(file))]
+	if !ok {
+		parser = parseIni
+	}
+
+	sections, err := parser(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	return sections, nil
+}
+
+func parseYAML(r io.Reader) (map[string]ConfigSection, error) {
+	tree := make(map[string]interface{})
+	if err := yaml.NewDecoder(r).Decode(&tree); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return treeSections(tree), nil
+}
+
+func parseTOML(r io.Reader) (map[string]ConfigSection, error) {
+	tree := make(map[string]interface{})
+	if _, err := toml.NewDecoder(r).Decode(&tree); err != nil {
+		return nil, err
+	}
+	return treeSections(tree), nil
+}
+
+func parseJSON(r io.Reader) (map[string]ConfigSection, error) {
+	tree := make(map[string]interface{})
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return treeSections(tree), nil
+}
+
+// group a decoded document by its top level keys, scalars at the top go
+// to the "" section
+func treeSections(tree map[string]interface{}) map[string]ConfigSection {
+	sections := map[string]ConfigSection{"": {}}
+	for k, v := range tree {
+		if m, ok := v.(map[string]interface{}); ok {
+			section := ConfigSection{}
+			for sk, sv := range m {
+				flattenValue(sk, sv, section)
+			}
+			sections[k] = section
+			continue
+		}
+		flattenValue(k, v, sections[""])
+	}
+	return sections
+}
+
+// store v under key, maps become dotted keys, lists of scalars a comma
+// separated value and other lists indexed keys like servers.0.host
+func flattenValue(key string, v interface{}, out ConfigSection) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, sv := range v {
+			flattenValue(key+"."+k, sv, out)
+		}
+	case []interface{}:
+		if s, ok := scalarList(v); ok {
+			out[key] = s
+			return
+		}
+		for i, sv := range v {
+			flattenValue(key+"."+strconv.Itoa(i), sv, out)
+		}
+	case []map[string]interface{}: // toml array of tables
+		for i, sv := range v {
+			flattenValue(key+"."+strconv.Itoa(i), sv, out)
+		}
+	default:
+		out[key] = scalarString(v)
+	}
+}
+
+func scalarList(list []interface{}) (string, bool) {
+	s := make([]string, len(list))
+	for i, v := range list {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}, []map[string]interface{}:
+			return "", false
+		}
+		s[i] = scalarString(v)
+	}
+	return strings.Join(s, ","), true
+}
+
+func scalarString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// config file names tried in dir, in ConfigExtensions order
+func configCandidates(dir string) []string {
+	files := make([]string, 0, len(ConfigExtensions))
+	for _, ext := range ConfigExtensions {
+		files = append(files, filepath.Join(dir, AppName+ext))
+	}
+	return files
+}
@@ -0,0 +1,78 @@
+package ebase
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadConfigFormats(t *testing.T) {
+	files := map[string]string{
+		"app.conf": `name = demo
+[database]
+host = db1
+port = 6432
+[redis]
+hosts = a,b
+`,
+		"app.yaml": `name: demo
+database:
+  host: db1
+  port: 6432
+redis:
+  hosts: [a, b]
+`,
+		"app.toml": `name = "demo"
+[database]
+host = "db1"
+port = 6432
+[redis]
+hosts = ["a", "b"]
+`,
+		"app.json": `{"name": "demo",
+ "database": {"host": "db1", "port": 6432},
+ "redis": {"hosts": ["a", "b"]}}`,
+	}
+
+	dir := t.TempDir()
+	for name, data := range files {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		sections, err := ReadConfigSections(file)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		cfg := NewConfiguration(NewMapSource(LayerFile, flattenSections(sections)))
+		for key, want := range map[string]string{
+			"name": "demo", "database.host": "db1", "database.port": "6432", "redis.hosts": "a,b",
+		} {
+			if v, _ := cfg.String(key, ""); v != want {
+				t.Errorf("%s: %s = %q, want %q", name, key, v, want)
+			}
+		}
+	}
+}
+
+func TestTreeSectionsNested(t *testing.T) {
+	sections := treeSections(map[string]interface{}{
+		"database": map[string]interface{}{
+			"replica": map[string]interface{}{"host": "db2"},
+			"servers": []interface{}{map[string]interface{}{"host": "s0"}},
+		},
+		"debug": true,
+	})
+
+	if v := sections["database"]["replica.host"]; v != "db2" {
+		t.Errorf("replica.host = %q", v)
+	}
+	if v := sections["database"]["servers.0.host"]; v != "s0" {
+		t.Errorf("servers.0.host = %q", v)
+	}
+	if v := sections[""]["debug"]; v != "true" {
+		t.Errorf("debug = %q", v)
+	}
+}
@@ -0,0 +1,210 @@
+package ebase
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// LSB exit code of "status" for a stopped program
+const ExitNotRunning = 3
+
+var stopFlags = flag.NewFlagSet("stop", flag.ContinueOnError)
+
+func init() {
+	stopWait := stopFlags.Int("t", 0, "Seconds to wait before SIGKILL [Default: sys.stop_timeout or 30]")
+
+	RegisterCommand(&Command{Name: "start", Usage: "Start unless already running", Continue: true,
+		Run: func(args []string) error {
+			file, err := ControlPidFile()
+			if err != nil {
+				return err
+			}
+			if pid, running, _ := PidStatus(file); running {
+				return fmt.Errorf("%s is already running (pid %d)", AppName, pid)
+			}
+			return nil
+		}})
+	RegisterCommand(&Command{Name: "stop", Usage: "Stop the running program", Flags: stopFlags,
+		Run: func(args []string) error {
+			return StopProcess(*stopWait)
+		}})
+	RegisterCommand(&Command{Name: "restart", Usage: "Stop the running program and start again",
+		Flags: stopFlags, Continue: true,
+		Run: func(args []string) error {
+			return StopProcess(*stopWait)
+		}})
+	RegisterCommand(&Command{Name: "reload", Usage: "Reload the config (SIGHUP)",
+		Run: func(args []string) error {
+			return SignalProcess(syscall.SIGHUP)
+		}})
+	RegisterCommand(&Command{Name: "graceful", Usage: "Restart without dropping connections (SIGUSR2)",
+		Run: func(args []string) error {
+			return SignalProcess(syscall.SIGUSR2)
+		}})
+	RegisterCommand(&Command{Name: "status", Usage: "Show whether the program is running",
+		Run: func(args []string) error {
+			return ProcessStatus()
+		}})
+}
+
+// ControlPidFile returns the pid file of the running program: -p, or
+// sys.pid from the config, or /var/run/<app>.pid. Relative paths are
+// taken in the -d work dir, as NewApp does
+func ControlPidFile() (file string, err error) {
+	err = inWorkDir(func() error {
+		if *pidfile != "" {
+			file, err = filepath.Abs(*pidfile)
+			return err
+		}
+		if cfg, _, err := ReadConfig(*cfgfile); err == nil {
+			if file, _ = cfg.String("sys.pid", ""); file != "" {
+				file, err = filepath.Abs(file)
+				return err
+			}
+		}
+		file = "/var/run/" + AppName + ".pid"
+		return nil
+	})
+
+	return
+}
+
+// run fn in the -d work dir, the config and pid paths are relative to it
+func inWorkDir(fn func() error) error {
+	if *workdir == "" {
+		return fn()
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err = os.Chdir(*workdir); err != nil {
+		return err
+	}
+	defer os.Chdir(cwd)
+
+	return fn()
+}
+
+// read the pid file, returns an ExitNotRunning error when stopped
+func runningPid() (int, string, error) {
+	file, err := ControlPidFile()
+	if err != nil {
+		return 0, "", err
+	}
+
+	pid, running, err := PidStatus(file)
+	if err != nil {
+		return 0, file, err
+	}
+	if !running {
+		return 0, file, &ExitCodeError{Code: ExitNotRunning,
+			Err: fmt.Errorf("%s is not running", AppName)}
+	}
+
+	return pid, file, nil
+}
+
+// SignalProcess sends sig to the program named by the pid file
+func SignalProcess(sig syscall.Signal) error {
+	pid, _, err := runningPid()
+	if err != nil {
+		return err
+	}
+
+	if err = syscall.Kill(pid, sig); err != nil {
+		return fmt.Errorf("send %s to pid %d: %s", sig, pid, err)
+	}
+	fmt.Printf("%s (pid %d): sent %s\n", AppName, pid, sig)
+
+	return nil
+}
+
+// StopProcess sends SIGTERM and waits up to wait seconds for the program
+// to exit, then sends SIGKILL. A stopped program is not an error.
+func StopProcess(wait int) error {
+	pid, file, err := runningPid()
+	var exitErr *ExitCodeError
+	if errors.As(err, &exitErr) {
+		fmt.Println(err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if wait <= 0 {
+		wait = 30
+		inWorkDir(func() error {
+			if cfg, _, err := ReadConfig(*cfgfile); err == nil {
+				wait, _ = cfg.Int("sys.stop_timeout", wait)
+			}
+			return nil
+		})
+	}
+
+	if err = syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("send SIGTERM to pid %d: %s", pid, err)
+	}
+	fmt.Printf("%s (pid %d): stopping", AppName, pid)
+
+	if waitExit(pid, time.Duration(wait)*time.Second) {
+		fmt.Println(" stopped")
+		return nil
+	}
+
+	fmt.Printf(" not stopped after %ds, killing", wait)
+	if err = syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		fmt.Println()
+		return fmt.Errorf("send SIGKILL to pid %d: %s", pid, err)
+	}
+
+	if !waitExit(pid, 5*time.Second) {
+		fmt.Println()
+		return fmt.Errorf("pid %d still running after SIGKILL", pid)
+	}
+	fmt.Println(" killed")
+
+	// a killed process leaves its pid file behind
+	if p, _, _ := PidStatus(file); p == pid {
+		os.Remove(file)
+	}
+
+	return nil
+}
+
+// poll until pid is gone
+func waitExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !processAlive(pid) {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return !processAlive(pid)
+}
+
+// ProcessStatus prints whether the program is running, stopped returns
+// an ExitNotRunning error
+func ProcessStatus() error {
+	pid, file, err := runningPid()
+	if err != nil {
+		return err
+	}
+
+	since := ""
+	if mtime, err := FileMTime(file); err == nil {
+		since = ", since " + time.Unix(mtime, 0).Format("2006-01-02 15:04:05")
+	}
+	fmt.Printf("%s is running (pid %d, pid file %s%s)\n", AppName, pid, file, since)
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package ebase
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// a child running sh -c script, reaped in the background so it does not
+// linger as a zombie once stopped, and a pid file naming it
+func controlChild(t *testing.T, script string) (*exec.Cmd, string, <-chan struct{}) {
+	dir := t.TempDir()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Dir = dir
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		<-done
+	})
+
+	file := filepath.Join(dir, "app.pid")
+	if err := os.WriteFile(file, []byte(GetIntStr(cmd.Process.Pid)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	old := *pidfile
+	*pidfile = file
+	t.Cleanup(func() { *pidfile = old })
+
+	return cmd, file, done
+}
+
+func TestPidStatus(t *testing.T) {
+	cmd, file, done := controlChild(t, "exec sleep 60")
+
+	pid, running, err := PidStatus(file)
+	if err != nil || !running || pid != cmd.Process.Pid {
+		t.Fatalf("pid %d running %v err %v", pid, running, err)
+	}
+	if err = ProcessStatus(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd.Process.Kill()
+	<-done
+	if pid, running, err = PidStatus(file); err != nil || running || pid != cmd.Process.Pid {
+		t.Fatalf("after exit pid %d running %v err %v", pid, running, err)
+	}
+	var exitErr *ExitCodeError
+	if err = ProcessStatus(); !errors.As(err, &exitErr) || exitErr.Code != ExitNotRunning {
+		t.Fatalf("status of a stopped program %v", err)
+	}
+
+	if pid, running, err = PidStatus(file + ".missing"); err != nil || running || pid != 0 {
+		t.Fatalf("missing file pid %d running %v err %v", pid, running, err)
+	}
+	os.WriteFile(file, []byte("x1\n"), 0644)
+	if _, _, err = PidStatus(file); err == nil {
+		t.Fatal("bad pid accepted")
+	}
+}
+
+func TestStopProcess(t *testing.T) {
+	_, file, done := controlChild(t, "exec sleep 60")
+
+	if err := StopProcess(5); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("process still running")
+	}
+	// the process removes its own pid file, a TERM'd sleep does not
+	if !IsExist(file) {
+		t.Fatal("pid file of a stopped process removed")
+	}
+
+	// stopping a stopped program is not an error
+	if err := StopProcess(5); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopProcessKill(t *testing.T) {
+	// a program ignoring SIGTERM, up once the ready file exists
+	_, file, done := controlChild(t, "trap '' TERM; touch ready; while :; do sleep 0.1; done")
+	for i := 0; !IsExist(filepath.Join(filepath.Dir(file), "ready")); i++ {
+		if i == 500 {
+			t.Fatal("child not up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	if err := StopProcess(1); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < time.Second {
+		t.Error("killed before the wait")
+	}
+	<-done
+	if IsExist(file) {
+		t.Fatal("pid file of a killed process left")
+	}
+}
+
+func TestControlPidFileWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	oldDir, oldPid := *workdir, *pidfile
+	defer func() { *workdir, *pidfile = oldDir, oldPid }()
+
+	// -d dir -p var/app.pid names the file NewApp writes after the chdir
+	*workdir, *pidfile = dir, "var/app.pid"
+	file, err := ControlPidFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "var/app.pid"); file != want {
+		t.Errorf("pid file %s, want %s", file, want)
+	}
+
+	*pidfile = "/run/app.pid"
+	if file, _ = ControlPidFile(); file != "/run/app.pid" {
+		t.Errorf("absolute pid file %s", file)
+	}
+}
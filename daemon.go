@@ -0,0 +1,272 @@
+package ebase
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// environment marker of a re-executed daemon child, the value is the fd of
+// the status pipe back to the parent
+const daemonEnv = "EBASE_DAEMON"
+
+type DaemonOptions struct {
+	NoChdir bool          // keep the work dir instead of changing to /
+	NoClose bool          // keep stdin/stdout/stderr of the parent
+	Umask   int           // file mode mask of the daemon, -1 keeps the inherited one
+	Stdout  string        // stdout file, empty for /dev/null
+	Stderr  string        // stderr file, empty for /dev/null
+	Timeout time.Duration // how long the parent waits for the child to start
+}
+
+// the status pipe of a daemon child, nil in any other process
+var daemonStatus *os.File
+
+func init() {
+	if v := os.Getenv(daemonEnv); v != "" {
+		os.Unsetenv(daemonEnv)
+		if fd, err := strconv.Atoi(v); err == nil {
+			daemonStatus = os.NewFile(uintptr(fd), "daemon-status")
+		}
+	}
+}
+
+// IsDaemonChild reports whether this process was started by StartDaemon
+func IsDaemonChild() bool {
+	return daemonStatus != nil
+}
+
+// daemon options from the sys section: sys.umask (octal), sys.stdout,
+// sys.stderr and sys.daemon_timeout (seconds)
+func DefaultDaemonOptions() *DaemonOptions {
+	opt := &DaemonOptions{Umask: 022, Timeout: 30 * time.Second}
+	if Config == nil {
+		return opt
+	}
+
+	if mask, _ := Config.String("sys.umask", ""); mask != "" {
+		if m, err := strconv.ParseInt(mask, 8, 32); err == nil {
+			opt.Umask = int(m)
+		}
+	}
+	opt.Stdout, _ = Config.String("sys.stdout", "")
+	opt.Stderr, _ = Config.String("sys.stderr", "")
+	if t, _ := Config.Int("sys.daemon_timeout", 0); t > 0 {
+		opt.Timeout = time.Duration(t) * time.Second
+	}
+
+	return opt
+}
+
+// StartDaemon re-executes the binary with the same arguments as a detached
+// session leader and waits until the child calls DaemonReady or Ready,
+// fails or exits. The pid file lock is released first so the child can take it.
+// A caller that keeps running should Wait on the returned process.
+func StartDaemon(opt *DaemonOptions) (*os.Process, error) {
+	if opt == nil {
+		opt = DefaultDaemonOptions()
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=3")
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if opt.NoClose {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	} else {
+		var files []*os.File
+		defer func() {
+			for _, f := range files {
+				f.Close()
+			}
+		}()
+
+		open := func(name string, flag int) (*os.File, error) {
+			if name == "" {
+				name = os.DevNull
+			}
+			f, err := os.OpenFile(name, flag, 0640)
+			if err == nil {
+				files = append(files, f)
+			}
+			return f, err
+		}
+
+		if cmd.Stdin, err = open("", os.O_RDONLY); err != nil {
+			closeFiles([]*os.File{r, w})
+			return nil, err
+		}
+		if cmd.Stdout, err = open(opt.Stdout, os.O_CREATE|os.O_WRONLY|os.O_APPEND); err != nil {
+			closeFiles([]*os.File{r, w})
+			return nil, err
+		}
+		if cmd.Stderr, err = open(opt.Stderr, os.O_CREATE|os.O_WRONLY|os.O_APPEND); err != nil {
+			closeFiles([]*os.File{r, w})
+			return nil, err
+		}
+	}
+
+	RemovePid()
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	if err = waitStatus(cmd, r, opt.Timeout, "daemon"); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// wait for the startup status the child started as cmd writes to r, nil
+// when it reported ready. A child that failed, exited or was not ready
+// within timeout is killed and reaped, what names it in the error.
+func waitStatus(cmd *exec.Cmd, r *os.File, timeout time.Duration, what string) error {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	select {
+	case s := <-readStatus(r):
+		if s == "OK" {
+			return nil
+		}
+
+		// the child closed the pipe or reported an error, collect its exit
+		cmd.Process.Kill()
+		cmd.Wait()
+		if s != "" {
+			return fmt.Errorf("%s start failed: %s", what, strings.TrimPrefix(s, "ERR "))
+		}
+		return fmt.Errorf("%s exited during startup: %s", what, cmd.ProcessState)
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("%s not ready after %s", what, timeout)
+	}
+}
+
+// the one line startup status written to r, "" when r is closed first
+func readStatus(r *os.File) <-chan string {
+	ch := make(chan string, 1)
+	go func() {
+		defer r.Close()
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		ch <- strings.TrimSpace(line)
+	}()
+	return ch
+}
+
+// DaemonReady tells the waiting parent that startup succeeded, it is a
+// no-op outside a daemon child. App.Run calls it once the components are
+// started.
+func DaemonReady() {
+	Ready()
+}
+
+// DaemonFailed passes a startup error to the waiting parent
+func DaemonFailed(err error) {
+	daemonReport("ERR " + strings.ReplaceAll(err.Error(), "\n", " "))
+}
+
+// write the startup status to the launcher or the restarting process
+func daemonReport(s string) {
+	for _, f := range []**os.File{&daemonStatus, &restartStatus} {
+		if *f != nil {
+			(*f).WriteString(s + "\n")
+			(*f).Close()
+			*f = nil
+		}
+	}
+}
+
+// Daemonize detaches the process. In the parent it does not return: it
+// exits 0 once the child is ready, or 1 printing the child's error. In the
+// child it applies the umask and work dir. The child must report DaemonReady
+// once it is set up, App.Run does so after starting the components; the
+// parent gives up after DaemonOptions.Timeout. A supervised worker or a
+// restarted process is already detached and returns at once.
+func Daemonize(opt *DaemonOptions) error {
+	if IsWorker() || IsRestartChild() {
+		return nil
+	}
+	if opt == nil {
+		opt = DefaultDaemonOptions()
+	}
+
+	if !IsDaemonChild() {
+		if _, err := StartDaemon(opt); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitFailure)
+		}
+		os.Exit(ExitOk)
+	}
+
+	if opt.Umask >= 0 {
+		syscall.Umask(opt.Umask)
+	}
+
+	if !opt.NoChdir {
+		if err := os.Chdir("/"); err != nil {
+			DaemonFailed(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// whether the process runs detached from a terminal: a daemon child, or a
+// service started by init or systemd
+func detached() bool {
+	return IsDaemonChild() || os.Getppid() == 1 || os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// Daemon detaches the process, see Daemonize. It returns 0 in the daemon
+// and -1 on failure. Its callers don't know DaemonReady, so the daemon is
+// reported ready at once.
+//
+// Deprecated: use Daemonize.
+func Daemon(nochdir, noclose int) int {
+	// already a daemon
+	if IsRestartChild() || !IsDaemonChild() && syscall.Getppid() == 1 {
+		return 0
+	}
+	if IsWorker() {
+		DaemonReady()
+		return 0
+	}
+
+	opt := DefaultDaemonOptions()
+	opt.NoChdir = nochdir != 0
+	opt.NoClose = noclose != 0
+
+	if err := Daemonize(opt); err != nil {
+		if Log != nil {
+			Log.Errorf("daemon error: %s", err)
+		}
+		return -1
+	}
+	DaemonReady()
+
+	return 0
+}
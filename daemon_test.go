@@ -0,0 +1,70 @@
+package ebase
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the test binary re-executed by StartDaemon acts out EBASE_TEST_DAEMON
+func init() {
+	mode := os.Getenv("EBASE_TEST_DAEMON")
+	if mode == "" || !IsDaemonChild() {
+		return
+	}
+
+	switch mode {
+	case "ready":
+		if err := Daemonize(&DaemonOptions{Umask: -1}); err != nil {
+			DaemonFailed(err)
+			os.Exit(ExitFailure)
+		}
+		if wd, _ := os.Getwd(); wd != "/" {
+			DaemonFailed(errors.New("work dir " + wd))
+			os.Exit(ExitFailure)
+		}
+		DaemonReady()
+	case "fail":
+		DaemonFailed(errors.New("bad config\nline 3"))
+		os.Exit(ExitFailure)
+	case "exit":
+		os.Exit(ExitFailure)
+	case "hang":
+		time.Sleep(time.Minute)
+	}
+	os.Exit(ExitOk)
+}
+
+func TestStartDaemon(t *testing.T) {
+	tests := []struct {
+		mode, err string
+	}{
+		{"ready", ""},
+		{"fail", "daemon start failed: bad config line 3"},
+		{"exit", "daemon exited during startup: exit status 1"},
+		{"hang", "daemon not ready after 500ms"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("EBASE_TEST_DAEMON", tt.mode)
+
+		timeout := 10 * time.Second
+		if tt.mode == "hang" {
+			timeout = 500 * time.Millisecond
+		}
+		p, err := StartDaemon(&DaemonOptions{Timeout: timeout})
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %s", tt.mode, err)
+				continue
+			}
+			p.Wait()
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %s", tt.mode, err, tt.err)
+		}
+	}
+}
@@ -7,179 +7,172 @@
 package ebase
 
 import (
-	"flag"
+	"context"
 	"fmt"
 	"os"
-	"os/signal"
 	"path"
-	"reflect"
 	"runtime"
+	"strings"
 	"syscall"
-
-	"github.com/forease/config"
+	"time"
 )
 
 var (
 	// 日志
 	Log        *BaseLog
-	Config     *config.Config
-	SigHandler = make(map[string]interface{}) //
-	G          = make(map[string]interface{}) //
-
-	// 定义命令行参数
-	verbose = flag.Bool("v", false, "Verbose output")
-	help    = flag.Bool("h", false, "Show this help")
-	chroot  = flag.Bool("w", false, "Setup enable chroot")
-	cfgfile = flag.String("c", "", "Config file")
-	workdir = flag.String("d", "", "Setup work dir")
-	pidfile = flag.String("p", "", "Pid file")
+	Config     *Configuration
+	SigHandler = make(map[string]interface{}) // deprecated, use HandleSignal
+	G          = make(map[string]interface{}) // deprecated, not safe for concurrent use, use Services
 
 	AppName = path.Base(os.Args[0])
+
+	// 定义命令行参数
+	verbose = Flags.Bool("v", false, "Show version and exit")
+	help    = Flags.Bool("h", false, "Display this message")
+	chroot  = Flags.Bool("w", false, "Enable chroot to work dir, requires -d")
+	cfgfile = Flags.String("c", "", "Server `config` file, .conf .yaml .toml or .json [Default: etc/"+AppName+".conf]")
+	cfgenv  = Flags.String("e", "", "Config `env` overlay, e.g. prod reads "+AppName+".prod.conf over "+AppName+".conf [Default: $"+EnvPrefix+"_ENV]")
+	workdir = Flags.String("d", "", "Work `dir`")
+	pidfile = Flags.String("p", "", "Pid `file` [Default: /var/run/"+AppName+".pid]")
+
+	// pid file locked by CreatePid
+	pidLock *PidFile
+	// config file loaded by EbaseInit
+	configPath string
 )
 
 func EbaseInit() {
-	flag.Parse()
-	if *help {
-		Help()
-		return
-	}
-
-	if *verbose {
-		fmt.Println("version:")
-		os.Exit(0)
-	}
+	handleCommandLine()
 
 	if *workdir != "" {
 		fmt.Println("workdir: ", *workdir, os.Args)
-		if err := syscall.Chdir(*workdir); err != nil {
-			fmt.Printf("Can't change to work dir [%s]: %s\n", *workdir, err)
-			os.Exit(1)
-		}
+	}
 
-		if *chroot {
-			pwd, _ := os.Getwd()
-			if err := syscall.Chroot(pwd); err != nil {
-				fmt.Printf("Can't Chroot to %s: %s\n", *workdir, err)
-				os.Exit(1)
-			}
-			fmt.Printf("I'll Chroot to %s !\n", pwd)
-		}
+	// a supervised worker leaves the pid file to its master
+	app, err := NewApp(&Options{ConfigFile: *cfgfile, PidFile: *pidfile,
+		NoPid: IsWorker(), WorkDir: *workdir, Chroot: *chroot})
+	if err != nil {
+		fmt.Println(err)
+		DaemonFailed(err)
+		os.Exit(1)
 	}
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	Config = LoadConfig("")
-	CreatePid()
-	Log = defaultLog()
+	MainApp = app
+	configPath = app.ConfigFile
+	Config = app.Config
+	Log = app.Log
+	setPidFile(app.Pid)
+	wireServices()
+	Reloader.Snapshot()
+	Reloader.Subscribe("log", reloadLog)
 
-	/*
-		SigHandler["sighup"] = func() {
-			Log.Debug("reload config")
-			Config = LoadConfig("")
+	if timeout, _ := Config.Int("sys.shutdown_timeout", 0); timeout > 0 {
+		Shutdowner.Timeout = time.Duration(timeout) * time.Second
+	}
+
+	// supervise once detached: a launcher that daemonizes supervises in
+	// its child, one run from a terminal when Run starts
+	if ok, _ := Config.Bool("sys.supervise", false); ok {
+		if detached() {
+			Supervise(nil)
 		}
+		supervisePending = true
+	}
 
-			if ok, _ := Config.Bool("sys.signal", false); ok {
-				go SignalHandle(SigHandler)
-			}
-	*/
-}
+	if ok, _ := Config.Bool("sys.signal", true); ok {
+		HandleSignal(syscall.SIGHUP, ReloadSignal)
+		HandleSignal(syscall.SIGUSR2, RestartSignal)
+		HandleSignal(syscall.SIGUSR1, ReopenLogSignal)
+		go SignalHandle(SigHandler)
+	}
 
-func LoadConfig(configFile string) (cfg *config.Config) {
-	var err error
-	if configFile == "" {
-		if *cfgfile != "" {
-			configFile = *cfgfile
-		} else {
-			files := []string{
-				AppName + ".conf",
-				"./etc/" + AppName + ".conf",
-				"/var/etc/" + AppName + ".conf",
-				"/opt/etc/" + AppName + ".conf",
-			}
+	go SdWatchdog(context.Background())
 
-			for _, ff := range files {
-				if _, err := os.Stat(ff); err == nil {
-					configFile = ff
-					break
-				}
-			}
-		}
-	}
-	if configFile == "" {
-		fmt.Println("config file not found!")
-		os.Exit(1)
+	if watch, _ := Config.Int("sys.config_watch", 0); watch > 0 {
+		go Reloader.Watch(context.Background(), time.Duration(watch)*time.Second)
 	}
+}
 
-	cfg, err = config.NewConfig(configFile, 16)
+func LoadConfig(configFile string) (cfg *Configuration) {
+	cfg, _, err := ReadConfig(configFile)
 	if err != nil {
-		fmt.Println("read config file error: ", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
 	return cfg
 }
 
-func defaultLog() (l *BaseLog) {
+// find the config file from -c or the default search list
+func findConfig(configFile string) string {
+	if configFile != "" {
+		return configFile
+	}
+	if *cfgfile != "" {
+		return *cfgfile
+	}
 
-	logType, _ := Config.String("log.type", "consloe")
-	logFile, _ := Config.String("log.file", "")
-	logLevel, _ := Config.Int("log.level", 5)
-	logFlag, _ := Config.Int("log.flag", 19)
-	//logEnable, _ = Config.Bool("log.enable", true)
-	opt := &LogOptions{Type: logType, File: logFile, Level: logLevel, Flag: logFlag}
+	for _, dir := range []string{".", "./etc", "/var/etc", "/opt/etc"} {
+		for _, ff := range configCandidates(dir) {
+			if _, err := os.Stat(ff); err == nil {
+				return ff
+			}
+		}
+	}
+
+	return ""
+}
+
+func defaultLog() (l *BaseLog) {
+	opt, err := defaultLogOptions()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	return NewLog(opt)
 }
 
-//
-func SignalHandle(funcs map[string]interface{}) {
-	ch := make(chan os.Signal)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
-
-	for {
-		select {
-		case s := <-ch:
-			switch s {
-			default:
-			case syscall.SIGHUP:
-				if f, ok := funcs["sighup"]; ok {
-					if ff := reflect.ValueOf(f); ff.Kind() == reflect.Func {
-						ff.Call(nil)
-					}
-				}
-				break
-			case syscall.SIGINT:
-				if f, ok := funcs["sigint"]; ok {
-					if ff := reflect.ValueOf(f); ff.Kind() == reflect.Func {
-						ff.Call(nil)
-					}
-				}
-				os.Exit(1)
-			case syscall.SIGUSR1:
-				if f, ok := funcs["sigusr1"]; ok {
-					if ff := reflect.ValueOf(f); ff.Kind() == reflect.Func {
-						ff.Call(nil)
-					}
-				}
-			case syscall.SIGUSR2:
-				if f, ok := funcs["sigusr2"]; ok {
-					if ff := reflect.ValueOf(f); ff.Kind() == reflect.Func {
-						ff.Call(nil)
-					}
-				}
-			}
+func defaultLogOptions() (*LogOptions, error) {
+	return configLogOptions(Config)
+}
+
+// log options from the log section, each [log.sink.<name>] section adds
+// a sink
+func configLogOptions(cfg *Configuration) (*LogOptions, error) {
+	opt := new(LogOptions)
+	if err := cfg.Bind("log", opt); err != nil {
+		return nil, err
+	}
+
+	for _, key := range cfg.Keys() {
+		if !strings.HasPrefix(key, logSinkPrefix) {
+			continue
+		}
+		name := key[len(logSinkPrefix):]
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			name = name[:i]
+		}
+		if _, ok := opt.Sinks[name]; ok {
+			continue
+		}
+
+		sink := new(LogOptions)
+		if err := cfg.Bind(logSinkPrefix+name, sink); err != nil {
+			return nil, err
 		}
+		if opt.Sinks == nil {
+			opt.Sinks = make(map[string]*LogOptions)
+		}
+		opt.Sinks[name] = sink
 	}
+
+	return opt, nil
 }
 
 // create pid file
 func CreatePid() {
-	pid := os.Getpid()
-
-	if pid < 1 {
-		fmt.Println("Get pid err")
-		os.Exit(1)
-	}
-
 	var pidf string
 	if *pidfile != "" {
 		pidf = *pidfile
@@ -190,99 +183,36 @@ func CreatePid() {
 		}
 	}
 
-	if pidf == "" {
-		fmt.Println("pid file not setup")
-		os.Exit(1)
-	}
-
-	f, err := os.OpenFile(pidf, os.O_CREATE|os.O_RDWR, 0666)
+	p, err := LockPid(pidf)
 	if err != nil {
-		fmt.Println("Open pid file err ", err)
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	f.WriteString(GetIntStr(pid))
-	f.Close()
+	setPidFile(p)
 }
 
-func Daemon(nochdir, noclose int) int {
-	var ret, ret2 uintptr
-	var err syscall.Errno
-
-	darwin := runtime.GOOS == "darwin"
-
-	// already a daemon
-	if syscall.Getppid() == 1 {
-		return 0
-	}
-
-	// fork off the parent process
-	ret, ret2, err = syscall.RawSyscall(syscall.SYS_FORK, 0, 0, 0)
-	if err != 0 {
-		return -1
-	}
-
-	// failure
-	if ret2 < 0 {
-		os.Exit(-1)
-	}
-
-	// handle exception for darwin
-	if darwin && ret2 == 1 {
-		ret = 0
-	}
-
-	// if we got a good PID, then we call exit the parent process.
-	if ret > 0 {
-		os.Exit(0)
-	}
-
-	/* Change the file mode mask */
-	_ = syscall.Umask(0)
-
-	// create a new SID for the child process
-	s_ret, s_errno := syscall.Setsid()
-	if s_errno != nil {
-		Log.Errorf("Error: syscall.Setsid errno: %d", s_errno)
-	}
-	if s_ret < 0 {
-		return -1
+// remember the pid file and remove it on shutdown
+func setPidFile(p *PidFile) {
+	if p == nil {
+		return
 	}
 
-	if nochdir == 0 {
-		os.Chdir("/")
-	}
+	pidLock = p
+	RegisterShutdown("pidfile", ShutdownLast, func(ctx context.Context) error {
+		return RemovePid()
+	})
+}
 
-	if noclose == 0 {
-		f, e := os.OpenFile("/dev/null", os.O_RDWR, 0)
-		if e == nil {
-			fd := f.Fd()
-			syscall.Dup2(int(fd), int(os.Stdin.Fd()))
-			syscall.Dup2(int(fd), int(os.Stdout.Fd()))
-			syscall.Dup2(int(fd), int(os.Stderr.Fd()))
-		}
-	}
+// remove the pid file written by CreatePid
+func RemovePid() error {
+	err := pidLock.Remove()
+	pidLock = nil
 
-	return 0
+	return err
 }
 
 func Help() {
-	fmt.Printf(
-		"\nUseage: %s [ Options ]\n\n"+
-			"Options:\n"+
-			"  -c Server config file [Default: etc/"+AppName+".conf]\n"+
-			"  -d Work dir [Default: publish]\n"+
-			"  -h Display this mssage\n"+
-			"  -p Pid file [Default: var/"+AppName+".pid]\n"+
-			"  -w Enable chroot to work dir [Required: -d ]\n\n"+
-			"------------------------------------------------------\n\n"+
-			"  Author:  16hot (im16hot@gmail.com) \n"+
-			"  Company: Beijing ForEase Times Technology Co., Ltd.\n"+
-			"  Website: http://www.forease.net\n"+
-			"  MyBlog:  http://16hot.com\n"+
-			"  Version: 1.0 Beta1\n\n"+
-			"------------------------------------------------------\n\n",
-		os.Args[0])
-
+	WriteHelp(os.Stdout)
 	os.Exit(0)
 }
@@ -0,0 +1,93 @@
+package ebase
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrConfigNotFound = errors.New("config file not found")
+	ErrPidLocked      = errors.New("pid file locked by another process")
+	ErrNoService      = errors.New("service not registered")
+	ErrServiceCycle   = errors.New("service dependency cycle")
+)
+
+// config file exists but can't be parsed
+type ConfigParseError struct {
+	File string
+	Err  error
+}
+
+func (e *ConfigParseError) Error() string {
+	return fmt.Sprintf("read config file %s error: %s", e.File, e.Err)
+}
+
+func (e *ConfigParseError) Unwrap() error { return e.Err }
+
+// pid file can't be created, Err is ErrPidLocked when another instance
+// holds it
+type PidFileError struct {
+	File string
+	Pid  int // pid of the running instance, if known
+	Err  error
+}
+
+func (e *PidFileError) Error() string {
+	if e.Pid > 0 {
+		return fmt.Sprintf("pid file %s error: %s (pid %d)", e.File, e.Err, e.Pid)
+	}
+	return fmt.Sprintf("pid file %s error: %s", e.File, e.Err)
+}
+
+func (e *PidFileError) Unwrap() error { return e.Err }
+
+// log output can't be opened
+type LogOpenError struct {
+	Type string
+	File string
+	Err  error
+}
+
+func (e *LogOpenError) Error() string {
+	if e.Type == "file" {
+		return fmt.Sprintf("open log file %s error: %s", e.File, e.Err)
+	}
+	return fmt.Sprintf("open %s log error: %s", e.Type, e.Err)
+}
+
+func (e *LogOpenError) Unwrap() error { return e.Err }
+
+// returned by a Command to exit with a specific code
+type ExitCodeError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitCodeError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit code %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitCodeError) Unwrap() error { return e.Err }
+
+// every missing or malformed key found by Configuration.Bind
+type ConfigBindError struct {
+	Section  string
+	Problems []string
+}
+
+func (e *ConfigBindError) Error() string {
+	return fmt.Sprintf("config [%s]: %s", e.Section, strings.Join(e.Problems, "; "))
+}
+
+// every problem found by Configuration.Check, one per line
+type ConfigCheckError struct {
+	Problems []string
+}
+
+func (e *ConfigCheckError) Error() string {
+	return strings.Join(e.Problems, "\n")
+}
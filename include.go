@@ -0,0 +1,191 @@
+package ebase
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// includes nested deeper than this are an error
+const maxIncludeDepth = 8
+
+func init() {
+	RegisterCommand(&Command{Name: "print-config", Usage: "Print the effective config, secrets redacted",
+		Run: func(args []string) error {
+			return inWorkDir(func() error {
+				cfg, file, err := ReadConfig(*cfgfile)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("# %s", file)
+				if env := ConfigEnv(); env != "" {
+					fmt.Printf(" + %s", OverlayFile(file, env))
+				}
+				fmt.Println()
+				return cfg.Dump(os.Stdout)
+			})
+		}})
+}
+
+// ConfigEnv returns the config environment, -e or $<PREFIX>_ENV
+func ConfigEnv() string {
+	if *cfgenv != "" {
+		return *cfgenv
+	}
+	return os.Getenv(EnvPrefix + "_ENV")
+}
+
+// OverlayFile returns the overlay of file for env, etc/app.prod.conf for
+// etc/app.conf and prod
+func OverlayFile(file, env string) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + env + ext
+}
+
+// ReadConfigFiles reads file and the files it includes, then the overlay
+// for env if env is not empty. Later files override earlier ones: an
+// included file overrides the file including it and the overlay overrides
+// the base config.
+//
+//	include = conf.d/*.conf, local.conf
+//
+// Include patterns are relative to the including file, a pattern without
+// matches is skipped unless it names a single file.
+func ReadConfigFiles(file, env string) (map[string]ConfigSection, error) {
+	return readConfigFiles(file, env, nil, nil)
+}
+
+// ReadConfigFiles recording the origin of each key when origins is not nil
+// and the files read when files is not nil
+func readConfigFiles(file, env string, origins map[string]string, files *[]string) (map[string]ConfigSection, error) {
+	sections, err := readIncludes(file, 0, make(map[string]bool), origins, files)
+	if err != nil || env == "" {
+		return sections, err
+	}
+
+	overlay := OverlayFile(file, env)
+	over, err := readIncludes(overlay, 0, make(map[string]bool), origins, files)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("config env %s: %s not found", env, overlay)
+		}
+		return nil, err
+	}
+	mergeSections(sections, over)
+
+	return sections, nil
+}
+
+func readIncludes(file string, depth int, seen map[string]bool, origins map[string]string, files *[]string) (map[string]ConfigSection, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	if seen[abs] {
+		return nil, fmt.Errorf("%s: include cycle", file)
+	}
+	seen[abs] = true
+	defer delete(seen, abs)
+	if files != nil {
+		*files = append(*files, abs)
+	}
+
+	sections, err := ReadConfigSections(file)
+	if err != nil {
+		return nil, err
+	}
+
+	include := sections[""]["include"]
+	delete(sections[""], "include")
+	if origins != nil {
+		recordOrigins(file, sections, origins)
+	}
+	if include == "" {
+		return sections, nil
+	}
+	if depth >= maxIncludeDepth {
+		return nil, fmt.Errorf("%s: includes nested too deep", file)
+	}
+
+	for _, pattern := range strings.FieldsFunc(include, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	}) {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(file), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include %s: %s", file, pattern, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("%s: include %s: file not found", file, pattern)
+		}
+
+		for _, m := range matches {
+			inc, err := readIncludes(m, depth+1, seen, origins, files)
+			if err != nil {
+				// not a missing config to the caller
+				return nil, fmt.Errorf("%s: include: %s", file, err)
+			}
+			mergeSections(sections, inc)
+		}
+	}
+
+	return sections, nil
+}
+
+// copy the keys of src over dst
+func mergeSections(dst, src map[string]ConfigSection) {
+	for name, section := range src {
+		if dst[name] == nil {
+			dst[name] = ConfigSection{}
+		}
+		for k, v := range section {
+			dst[name][k] = v
+		}
+	}
+}
+
+// Dump writes the effective config in ini format, values not from the
+// config file are marked with their layer. Secrets are redacted.
+func (c *Configuration) Dump(w io.Writer) error {
+	sections := c.Sections()
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name != "" {
+			if _, err := fmt.Fprintf(w, "\n[%s]\n", name); err != nil {
+				return err
+			}
+		}
+
+		keys := make([]string, 0, len(sections[name]))
+		for k := range sections[name] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			key := k
+			if name != "" {
+				key = name + "." + k
+			}
+			line := k + " = " + Redact(key, sections[name][k])
+			if layer := c.Source(key); layer != LayerFile {
+				line += "  # " + layer
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
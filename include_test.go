@@ -0,0 +1,97 @@
+package ebase
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		file := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app.conf":           "include = conf.d/*.conf\n[database]\nhost = base\nport = 5432\nuser = app\n",
+		"conf.d/10-db.conf":  "[database]\nport = 6432\n",
+		"conf.d/20-log.yaml": "log: {level: 3}\n", // not matched by *.conf
+		"app.prod.conf":      "include = prod.d/redis.toml\n[database]\nhost = prod-db\n",
+		"prod.d/redis.toml":  "[redis]\nenable = true\n",
+	})
+	file := filepath.Join(dir, "app.conf")
+
+	sections, err := ReadConfigFiles(file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := sections["database"]; s["host"] != "base" || s["port"] != "6432" {
+		t.Errorf("base config %v", s)
+	}
+	if _, ok := sections[""]["include"]; ok || sections["log"] != nil {
+		t.Errorf("include handled wrong: %v", sections)
+	}
+
+	sections, err = ReadConfigFiles(file, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := sections["database"]; s["host"] != "prod-db" || s["port"] != "6432" || s["user"] != "app" {
+		t.Errorf("prod config %v", s)
+	}
+	if sections["redis"]["enable"] != "true" {
+		t.Errorf("prod include missing: %v", sections["redis"])
+	}
+
+	if _, err = ReadConfigFiles(file, "staging"); err == nil {
+		t.Error("missing overlay accepted")
+	}
+}
+
+func TestReadConfigIncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"loop.conf":    "include = loop2.conf\n",
+		"loop2.conf":   "include = loop.conf\n",
+		"missing.conf": "include = nothere.conf\n",
+	})
+
+	for _, name := range []string{"loop.conf", "missing.conf"} {
+		_, err := ReadConfigFiles(filepath.Join(dir, name), "")
+		if err == nil || os.IsNotExist(err) {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
+
+func TestConfigurationDump(t *testing.T) {
+	cfg := NewConfiguration(
+		NewMapSource(LayerFile, map[string]string{
+			"name":              "demo",
+			"database.host":     "db1",
+			"database.password": "s3cret",
+		}),
+		NewMapSource(LayerFlag, map[string]string{"database.port": "6432"}),
+	)
+
+	var buf bytes.Buffer
+	if err := cfg.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "name = demo\n\n[database]\nhost = db1\npassword = ******\nport = 6432  # flag\n"
+	if buf.String() != want {
+		t.Errorf("dump\n%s\nwant\n%s", buf.String(), want)
+	}
+	if strings.Contains(buf.String(), "s3cret") {
+		t.Error("secret in dump")
+	}
+}
@@ -0,0 +1,292 @@
+package ebase
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// environment of a process started by Restart: the inherited listener
+// names in fd order starting at 3, and the fd of the ready pipe
+const (
+	listenFdsEnv    = "EBASE_LISTEN_FDS"
+	restartReadyEnv = "EBASE_RESTART_READY"
+)
+
+var (
+	listenerLock sync.Mutex
+	listeners    = make(map[string]net.Listener)
+	listenOrder  []string
+	inherited    = make(map[string]*os.File)
+
+	// sockets from systemd socket activation
+	sdOnce      sync.Once
+	sdInherited map[string]net.Listener
+
+	// ready pipe and pid of the process that restarted us
+	restartStatus *os.File
+	restartParent int
+)
+
+func init() {
+	inheritListeners(3)
+
+	if v := os.Getenv(restartReadyEnv); v != "" {
+		os.Unsetenv(restartReadyEnv)
+		if fd, err := strconv.Atoi(v); err == nil {
+			restartStatus = os.NewFile(uintptr(fd), "restart-status")
+			restartParent = os.Getppid()
+		}
+	}
+
+	// close listeners first on shutdown so no new connections are accepted
+	RegisterShutdown("listeners", ShutdownFirst, func(ctx context.Context) error {
+		return CloseListeners()
+	})
+}
+
+// take the listeners named in EBASE_LISTEN_FDS, passed in fd order from
+// first
+func inheritListeners(first int) {
+	if names := os.Getenv(listenFdsEnv); names != "" {
+		os.Unsetenv(listenFdsEnv)
+		for i, name := range strings.Split(names, ",") {
+			inherited[name] = os.NewFile(uintptr(first+i), name)
+		}
+	}
+}
+
+// IsRestartChild reports whether this process was started by Restart
+func IsRestartChild() bool {
+	return restartParent > 0
+}
+
+// Listen returns a listener registered under name. After a Restart the
+// socket inherited from the old process is reused, under systemd socket
+// activation the socket whose FileDescriptorName is name, otherwise a new
+// one is bound. Only tcp and unix listeners can be handed over.
+func Listen(name, network, address string) (net.Listener, error) {
+	listenerLock.Lock()
+	defer listenerLock.Unlock()
+
+	if _, ok := listeners[name]; ok {
+		return nil, fmt.Errorf("listener %s already registered", name)
+	}
+
+	sdOnce.Do(func() {
+		var err error
+		if sdInherited, err = SdListeners(); err != nil && Log != nil {
+			Log.Errorf("systemd socket activation error: %s", err)
+		}
+	})
+
+	var l net.Listener
+	var err error
+	if f, ok := inherited[name]; ok {
+		delete(inherited, name)
+		l, err = net.FileListener(f)
+		f.Close()
+	} else if sl, ok := sdInherited[name]; ok {
+		delete(sdInherited, name)
+		l = sl
+	} else {
+		l, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	listeners[name] = l
+	listenOrder = append(listenOrder, name)
+
+	return l, nil
+}
+
+// CloseListeners stops accepting on every registered listener
+func CloseListeners() error {
+	listenerLock.Lock()
+	defer listenerLock.Unlock()
+
+	var err error
+	for _, name := range listenOrder {
+		if e := listeners[name].Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(listeners, name)
+	}
+	listenOrder = nil
+
+	return err
+}
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Restart starts a new copy of the binary handing over the registered
+// listeners and the pid file, and waits until it calls Ready. The caller
+// then drains its connections and exits; see RestartSignal. A supervised
+// worker asks its master to start the new worker, so the master keeps
+// supervising it.
+func Restart(timeout time.Duration) (*os.Process, error) {
+	listenerLock.Lock()
+	names := make([]string, 0, len(listenOrder))
+	files := make([]*os.File, 0, len(listenOrder)+1)
+	for _, name := range listenOrder {
+		fl, ok := listeners[name].(filer)
+		if !ok {
+			continue
+		}
+		f, err := fl.File()
+		if err != nil {
+			listenerLock.Unlock()
+			closeFiles(files)
+			return nil, fmt.Errorf("listener %s: %s", name, err)
+		}
+		names = append(names, name)
+		files = append(files, f)
+	}
+	listenerLock.Unlock()
+	defer closeFiles(files)
+
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	if supervisorConn != nil {
+		return restartSupervised(names, files, timeout)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, w)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(),
+		listenFdsEnv+"="+strings.Join(names, ","),
+		restartReadyEnv+"="+GetIntStr(3+len(names)))
+	cmd.ExtraFiles = files
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	// let the new process take the pid file over
+	pidLock.unlock()
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		pidLock.relock()
+		return nil, err
+	}
+
+	if err = waitStatus(cmd, r, timeout, "restarted process"); err != nil {
+		pidLock.relock()
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// hand the listeners to the master and wait for its new worker
+func restartSupervised(names []string, files []*os.File, timeout time.Duration) (*os.Process, error) {
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+
+	msg := fmt.Sprintf("RESTART %d %s", timeout, strings.Join(names, ","))
+	if _, _, err := supervisorConn.WriteMsgUnix([]byte(msg), oob, nil); err != nil {
+		return nil, fmt.Errorf("restart: %s", err)
+	}
+
+	// the master gives up on the new worker after timeout
+	supervisorConn.SetReadDeadline(time.Now().Add(timeout + 5*time.Second))
+	defer supervisorConn.SetReadDeadline(time.Time{})
+	line, err := bufio.NewReader(supervisorConn).ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("restart: no answer from the supervisor: %s", err)
+	}
+
+	line = strings.TrimSpace(line)
+	if pid, ok := strings.CutPrefix(line, "OK "); ok {
+		if n, err := strconv.Atoi(pid); err == nil {
+			return os.FindProcess(n)
+		}
+	}
+	return nil, fmt.Errorf("restart failed: %s", strings.TrimPrefix(line, "ERR "))
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// Ready tells whoever waits for this process that startup is complete:
+// the daemon launcher, the process that restarted us, or systemd. Call it
+// once the listeners are set up. After a restart the MAINPID sent here
+// only counts with NotifyAccess=all, RestartSignal in the old process
+// hands the main pid over for the default.
+func Ready() {
+	daemonReport("OK")
+
+	if IsRestartChild() {
+		sdNotify("MAINPID=" + GetIntStr(os.Getpid()) + "\nREADY=1")
+	} else {
+		sdNotify("READY=1")
+	}
+}
+
+// RestartSignal is the SIGUSR2 handler: it restarts the binary and, once
+// the new process is ready, closes the listeners and shuts down.
+func RestartSignal(sig os.Signal) error {
+	listenerLock.Lock()
+	n := len(listenOrder)
+	listenerLock.Unlock()
+	if n == 0 {
+		return errors.New("restart: no listeners registered")
+	}
+
+	timeout := 30 * time.Second
+	if Config != nil {
+		if t, _ := Config.Int("sys.restart_timeout", 0); t > 0 {
+			timeout = time.Duration(t) * time.Second
+		}
+	}
+
+	p, err := Restart(timeout)
+	if err != nil {
+		return err
+	}
+
+	// systemd takes notifications from the main pid only (NotifyAccess=main),
+	// so hand it over from here: the new process's own MAINPID is dropped.
+	// A supervised worker is not the main pid, its master stays
+	if !IsWorker() {
+		sdNotify("MAINPID=" + GetIntStr(p.Pid))
+	}
+	if Log != nil {
+		Log.Infof("restart: new process pid %d ready, draining", p.Pid)
+	}
+	go Shutdown(ExitOk)
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package ebase
+
+import (
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// dup f to the lowest free fd from min
+func dupFrom(t *testing.T, f *os.File, min int) int {
+	fd, _, e := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_DUPFD, uintptr(min))
+	if e != 0 {
+		t.Fatal(e)
+	}
+	return int(fd)
+}
+
+func listenerFile(t *testing.T) (net.Listener, *os.File) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close(); f.Close() })
+	return l, f
+}
+
+func TestListenInherited(t *testing.T) {
+	defer CloseListeners()
+
+	// two sockets handed over in consecutive fds, the way Restart passes them
+	la, fa := listenerFile(t)
+	lb, fb := listenerFile(t)
+	first := dupFrom(t, fa, 200)
+	if dupFrom(t, fb, first+1) != first+1 {
+		t.Skip("no consecutive free fds")
+	}
+	t.Setenv(listenFdsEnv, "a,b")
+	inheritListeners(first)
+	if _, ok := os.LookupEnv(listenFdsEnv); ok {
+		t.Error("environment kept")
+	}
+
+	a, err := Listen("a", "tcp", "127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Addr().String() != la.Addr().String() {
+		t.Errorf("a bound %s, inherited %s", a.Addr(), la.Addr())
+	}
+	b, err := Listen("b", "tcp", "127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Addr().String() != lb.Addr().String() {
+		t.Errorf("b bound %s, inherited %s", b.Addr(), lb.Addr())
+	}
+
+	// the inherited socket accepts
+	go func() {
+		if c, err := net.Dial("tcp", a.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := a.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	if _, err = Listen("a", "tcp", "127.0.0.1:0"); err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Errorf("second a: %v", err)
+	}
+	if len(inherited) != 0 {
+		t.Errorf("inherited left %v", inherited)
+	}
+}
+
+func TestListenNew(t *testing.T) {
+	defer CloseListeners()
+
+	l, err := Listen("new", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Listen("bad", "tcp", "no such host:x"); err == nil {
+		t.Error("bad address accepted")
+	}
+	if len(listenOrder) != 1 || listenOrder[0] != "new" {
+		t.Errorf("registered %v", listenOrder)
+	}
+
+	if err = CloseListeners(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Accept(); err == nil {
+		t.Error("accept after close")
+	}
+	if len(listeners) != 0 || len(listenOrder) != 0 {
+		t.Errorf("registry left %v %v", listeners, listenOrder)
+	}
+
+	// the name is free again
+	if _, err = Listen("new", "tcp", "127.0.0.1:0"); err != nil {
+		t.Error(err)
+	}
+}
@@ -7,10 +7,15 @@
 package ebase
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
-	"log/syslog"
 	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Log levels to control the logging output.
@@ -29,14 +34,62 @@ type BaseLog struct {
 	LogLevel int
 	LogType  string
 	//lChan chan
+
+	lock     sync.Mutex
+	sinks    []*logSink   // the main output first, nil once closed
+	maxLevel atomic.Int32 // highest level of the sinks
+	queue    *logQueue    // async mode
+	dropped  uint64       // drops of stopped queues
+
+	root   *BaseLog      // the logger a With child writes through
+	fields []interface{} // key-value pairs added by With
+}
+
+// config sections of the log sinks, log.sink.<name>
+const logSinkPrefix = "log.sink."
+
+// one output of a BaseLog with its own level and format
+type logSink struct {
+	name   string
+	level  int
+	format string
+	flag   int // Flag option, the logger has no flags for json and logfmt
+	out    io.Writer
+	logger *log.Logger
 }
 
 type LogOptions struct {
-	Type   string // log type: consloe, file, system
-	File   string // log file, need type is file
-	Level  int    // output log level
-	Flag   int    // log flag
-	Enable bool
+	Type   string `cfg:"type" default:"console" values:"console,file,syslog,system"` // log type: console, file, syslog
+	File   string `cfg:"file"`                                                               // log file, need type is file
+	Level  int    `cfg:"level" default:"5"`                                                  // output log level
+	Flag   int    `cfg:"flag" default:"19"`                                                  // log flag
+	Format string `cfg:"format" default:"text" values:"text,json,logfmt"`                    // line format
+	Enable bool   `cfg:"enable"`
+
+	// file rotation, need type is file
+	MaxSize  int    `cfg:"max_size"`                     // rotate at this many MB
+	Rotate   string `cfg:"rotate" values:"daily,hourly"` // rotate on schedule
+	MaxFiles int    `cfg:"max_files"`                    // rotated files kept
+	MaxAge   int    `cfg:"max_age"`                      // days rotated files are kept
+	Compress bool   `cfg:"compress"`                     // gzip rotated files
+
+	// syslog, need type is syslog
+	Facility string `cfg:"facility" default:"user" values:"kern,user,mail,daemon,auth,syslog,lpr,news,uucp,cron,authpriv,ftp,local0,local1,local2,local3,local4,local5,local6,local7"`
+	Tag      string `cfg:"tag"`                          // app name, default the program name
+	Network  string `cfg:"network" values:"udp,tcp,tls"` // remote syslog, empty for the local daemon
+	Addr     string `cfg:"addr"`                         // remote syslog host:port
+	TLSCA    string `cfg:"tls_ca"`                       // CA verifying the tls server, default the system roots
+	TLSCert  string `cfg:"tls_cert"`                     // client certificate for tls
+	TLSKey   string `cfg:"tls_key"`
+
+	// write from a goroutine through a bounded queue
+	Async     bool   `cfg:"async"`
+	QueueSize int    `cfg:"queue_size" default:"1024"`
+	QueueFull string `cfg:"queue_full" default:"block" values:"block,drop_newest,drop_lowest"` // when the queue is full
+
+	// more outputs by name, each with its own level and format. Read from
+	// the [log.sink.<name>] sections.
+	Sinks map[string]*LogOptions `cfg:"-"`
 }
 
 // New log
@@ -44,125 +97,277 @@ type LogOptions struct {
 // 	opt := &LogOptions{Type: logType, File: logFile, Level: logLevel, Flag: logFlag}
 //	log := NewLog(opt)
 func NewLog(opt *LogOptions) (l *BaseLog) {
-	var loger *log.Logger
+	l, err := OpenLog(opt)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return
+}
+
+// OpenLog is NewLog returning a *LogOpenError instead of exiting
+func OpenLog(opt *LogOptions) (*BaseLog, error) {
+	sinks, err := openSinks(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &BaseLog{Loger: sinks[0].logger, LogFile: opt.File,
+		LogType: opt.Type, LogLevel: opt.Level, sinks: sinks}
+	l.maxLevel.Store(int32(maxSinkLevel(sinks)))
+	l.setQueue(opt)
+
+	return l, nil
+}
+
+// open the main output and the sinks of opt, sorted by name
+func openSinks(opt *LogOptions) ([]*logSink, error) {
+	main, err := openSink("", opt)
+	if err != nil {
+		return nil, err
+	}
+	sinks := []*logSink{main}
+
+	names := make([]string, 0, len(opt.Sinks))
+	for name := range opt.Sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s, err := openSink(name, opt.Sinks[name])
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
 
+	return sinks, nil
+}
+
+func openSink(name string, opt *LogOptions) (*logSink, error) {
 	if opt.Level == -1 {
 		opt.Level = 3
 	}
 
+	out, err := newLogWriter(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logSink{name: name, level: opt.Level, format: logFormat(opt), flag: opt.Flag,
+		out: out, logger: log.New(out, "", loggerFlag(opt))}, nil
+}
+
+func maxSinkLevel(sinks []*logSink) int {
+	level := levelNone
+	for _, s := range sinks {
+		if s.level > level {
+			level = s.level
+		}
+	}
+	return level
+}
+
+func closeSinks(sinks []*logSink) error {
+	var err error
+	for _, s := range sinks {
+		if e := closeLogWriter(s.out); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// open the writer for the log type
+func newLogWriter(opt *LogOptions) (io.Writer, error) {
 	switch opt.Type {
-	case "consloe":
-		loger = log.New(os.Stdout, "", opt.Flag)
+	case "console", "consloe": // consloe of old configs, check-config reports it
+		return os.Stdout, nil
 	case "file":
-		out, err := os.OpenFile(opt.File, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0660)
+		out, err := newRotateWriter(opt)
 		if err != nil {
-			fmt.Println("Open log file error", err)
-			os.Exit(1)
+			return nil, &LogOpenError{Type: opt.Type, File: opt.File, Err: err}
 		}
-		loger = log.New(out, "", opt.Flag)
+		return out, nil
 	default:
-		var err error
-		loger, err = syslog.NewLogger(syslog.Priority(opt.Level), opt.Flag)
+		out, err := newSyslogWriter(opt)
 		if err != nil {
-			fmt.Println("init syslog error ", err)
-			os.Exit(1)
+			return nil, &LogOpenError{Type: "syslog", Err: err}
 		}
+		return out, nil
+	}
+}
+
+// Reopen switches the log to opt, the old outputs are closed. It is used
+// on config reload and keeps the *BaseLog shared by callers.
+func (l *BaseLog) Reopen(opt *LogOptions) error {
+	sinks, err := openSinks(opt)
+	if err != nil {
+		return err
 	}
 
-	l = &BaseLog{Loger: loger, LogFile: opt.File,
-		LogType: opt.Type, LogLevel: opt.Level}
+	l = l.base()
+	l.lock.Lock()
+	old := l.sinks
+	// callers may hold the Loger, the main sink keeps it
+	l.Loger.SetOutput(sinks[0].out)
+	l.Loger.SetFlags(sinks[0].logger.Flags())
+	sinks[0].logger = l.Loger
+	l.sinks = sinks
+	l.LogFile = opt.File
+	l.LogType = opt.Type
+	l.LogLevel = opt.Level
+	l.maxLevel.Store(int32(maxSinkLevel(sinks)))
+	q := l.queue
+	stopped := l.setQueue(opt)
+	l.lock.Unlock()
 
-	return
+	// records queued for the old outputs go out first
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if stopped != nil {
+		stopped.close(ctx)
+	} else if q != nil {
+		q.flush(ctx)
+	}
+	closeSinks(old)
+
+	return nil
 }
 
-func (l *BaseLog) Critical(v ...interface{}) {
-	if l.LogLevel >= LevelCritical {
-		l.Loger.Output(2, "[Crt]"+fmt.Sprintln(v...))
+// close the log outputs, console output is left open. An async log writes
+// the queued records first.
+func (l *BaseLog) Close() error {
+	l = l.base()
+	l.lock.Lock()
+	stopped := l.setQueue(&LogOptions{})
+	l.lock.Unlock()
+
+	if stopped != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stopped.close(ctx)
 	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	err := closeSinks(l.sinks)
+	l.sinks = nil
+
+	return err
 }
 
-func (l *BaseLog) Error(v ...interface{}) {
-	if l.LogLevel >= LevelError {
-		l.Loger.Output(2, "[Err] "+fmt.Sprintln(v...))
+func closeLogWriter(w io.Writer) error {
+	if c, ok := w.(io.Closer); ok && w != os.Stdout && w != os.Stderr {
+		return c.Close()
 	}
+	return nil
+}
+
+func (l *BaseLog) Critical(v ...interface{}) {
+	l.print(LevelCritical, v)
+}
+
+func (l *BaseLog) Error(v ...interface{}) {
+	l.print(LevelError, v)
 }
 
 func (l *BaseLog) Warn(v ...interface{}) {
-	if l.LogLevel >= LevelWarning {
-		l.Loger.Output(2, "[War] "+fmt.Sprintln(v...))
-	}
+	l.print(LevelWarning, v)
 }
 
 func (l *BaseLog) Info(v ...interface{}) {
-	if l.LogLevel >= LevelInfo {
-		l.Loger.Output(2, "[Inf] "+fmt.Sprintln(v...))
-	}
+	l.print(LevelInfo, v)
 }
 
 func (l *BaseLog) Debug(v ...interface{}) {
-	if l.LogLevel >= LevelDebug {
-		l.Loger.Output(2, "[Dbg] "+fmt.Sprintln(v...))
-	}
+	l.print(LevelDebug, v)
 }
 
 func (l *BaseLog) Trace(v ...interface{}) {
-	if l.LogLevel >= LevelTrace {
-		l.Loger.Output(2, "[Trc] "+fmt.Sprintln(v...))
-	}
+	l.print(LevelTrace, v)
+}
+
+// Criticalw logs msg with key-value pairs:
+//
+//	Log.Infow("request done", "user", id, "latency", d) // [Inf] request done user=42 latency=1.5s
+func (l *BaseLog) Criticalw(msg string, kv ...interface{}) {
+	l.printw(LevelCritical, msg, kv)
+}
+
+func (l *BaseLog) Errorw(msg string, kv ...interface{}) {
+	l.printw(LevelError, msg, kv)
+}
+
+func (l *BaseLog) Warnw(msg string, kv ...interface{}) {
+	l.printw(LevelWarning, msg, kv)
+}
+
+func (l *BaseLog) Infow(msg string, kv ...interface{}) {
+	l.printw(LevelInfo, msg, kv)
+}
+
+func (l *BaseLog) Debugw(msg string, kv ...interface{}) {
+	l.printw(LevelDebug, msg, kv)
+}
+
+func (l *BaseLog) Tracew(msg string, kv ...interface{}) {
+	l.printw(LevelTrace, msg, kv)
 }
 
 func (l *BaseLog) Println(v ...interface{}) {
-	l.Loger.Output(2, fmt.Sprintln(v...))
+	l.output(levelNone, fmt.Sprintln(v...), nil)
 }
 
 func (l *BaseLog) Panic(v ...interface{}) {
 	s := fmt.Sprintln(v...)
-	l.Loger.Output(2, s)
+	l.output(levelNone, s, nil)
 	panic(s)
 }
 
 func (l *BaseLog) Criticalf(format string, v ...interface{}) {
-	if l.LogLevel >= LevelCritical {
-		l.Loger.Output(2, "[Crt] "+fmt.Sprintf(format, v...))
-	}
+	l.printf(LevelCritical, format, v)
 }
 
 func (l *BaseLog) Errorf(format string, v ...interface{}) {
-	if l.LogLevel >= LevelError {
-		l.Loger.Output(2, "[Err] "+fmt.Sprintf(format, v...))
-	}
+	l.printf(LevelError, format, v)
 }
 
 func (l *BaseLog) Warnf(format string, v ...interface{}) {
-	if l.LogLevel >= LevelWarning {
-		l.Loger.Output(2, "[War] "+fmt.Sprintf(format, v...))
-	}
+	l.printf(LevelWarning, format, v)
 }
 
 func (l *BaseLog) Infof(format string, v ...interface{}) {
-	if l.LogLevel >= LevelInfo {
-		l.Loger.Output(2, "[Inf] "+fmt.Sprintf(format, v...))
-	}
+	l.printf(LevelInfo, format, v)
 }
 
 func (l *BaseLog) Debugf(format string, v ...interface{}) {
-	if l.LogLevel >= LevelDebug {
-		l.Loger.Output(2, "[Dbg] "+fmt.Sprintf(format, v...))
-	}
+	l.printf(LevelDebug, format, v)
 }
 
 func (l *BaseLog) Tracef(format string, v ...interface{}) {
-	if l.LogLevel >= LevelTrace {
-		l.Loger.Output(2, "[Trc] "+fmt.Sprintf(format, v...))
-	}
+	l.printf(LevelTrace, format, v)
 }
 
 func (l *BaseLog) Printf(format string, v ...interface{}) {
-	l.Loger.Output(2, fmt.Sprintf(format, v...))
+	l.output(levelNone, fmt.Sprintf(format, v...), nil)
 }
 
 func (l *BaseLog) Panicf(format string, v ...interface{}) {
 	s := fmt.Sprintf(format, v...)
-	l.Loger.Output(2, s)
+	l.output(levelNone, s, nil)
 	panic(s)
 }
+
+// log to Log at info level, to stderr before Log is set up
+func logInfof(format string, v ...interface{}) {
+	if Log != nil {
+		Log.Infof(format, v...)
+	} else {
+		fmt.Fprintf(os.Stderr, format+"\n", v...)
+	}
+}
@@ -0,0 +1,243 @@
+package ebase
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// what an async log does with a record when its queue is full,
+// LogOptions.QueueFull
+const (
+	QueueBlock      = "block"       // wait for room
+	QueueDropNewest = "drop_newest" // drop the new record
+	QueueDropLowest = "drop_lowest" // drop the least severe record, the new one if none is less severe
+)
+
+// default queue length of an async log
+const DefaultLogQueueSize = 1024
+
+// a rendered line waiting for the writer goroutine
+type logRecord struct {
+	sink  *logSink
+	level int
+	data  []byte
+}
+
+// bounded ring of records written by one goroutine
+type logQueue struct {
+	lock    sync.Mutex
+	cond    *sync.Cond // signaled on every change
+	ring    []logRecord
+	head    int
+	n       int
+	policy  string
+	busy    bool // the writer holds a record
+	closed  bool
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+func newLogQueue(size int, policy string) *logQueue {
+	if size <= 0 {
+		size = DefaultLogQueueSize
+	}
+	q := &logQueue{ring: make([]logRecord, size), policy: policy, done: make(chan struct{})}
+	q.cond = sync.NewCond(&q.lock)
+	go q.run()
+
+	return q
+}
+
+// push queues r, false when the queue is closed and r should be written
+// directly
+func (q *logQueue) push(r logRecord) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for q.n == len(q.ring) && !q.closed {
+		switch q.policy {
+		case QueueDropNewest:
+			q.dropped.Add(1)
+			return true
+		case QueueDropLowest:
+			if !q.dropLower(r.level) {
+				q.dropped.Add(1)
+				return true
+			}
+		default:
+			q.cond.Wait()
+		}
+	}
+	if q.closed {
+		return false
+	}
+
+	q.ring[(q.head+q.n)%len(q.ring)] = r
+	q.n++
+	q.cond.Broadcast()
+
+	return true
+}
+
+// remove the least severe queued record if it is less severe than level,
+// must hold lock
+func (q *logQueue) dropLower(level int) bool {
+	idx := -1
+	for i := 0; i < q.n; i++ {
+		r := q.ring[(q.head+i)%len(q.ring)]
+		if r.level > level && (idx < 0 || r.level > q.ring[(q.head+idx)%len(q.ring)].level) {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return false
+	}
+
+	for i := idx; i < q.n-1; i++ {
+		q.ring[(q.head+i)%len(q.ring)] = q.ring[(q.head+i+1)%len(q.ring)]
+	}
+	q.n--
+	q.ring[(q.head+q.n)%len(q.ring)] = logRecord{}
+	q.dropped.Add(1)
+
+	return true
+}
+
+func (q *logQueue) run() {
+	defer close(q.done)
+
+	q.lock.Lock()
+	for {
+		for q.n == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.n == 0 {
+			q.lock.Unlock()
+			return
+		}
+
+		r := q.ring[q.head]
+		q.ring[q.head] = logRecord{}
+		q.head = (q.head + 1) % len(q.ring)
+		q.n--
+		q.busy = true
+		q.cond.Broadcast()
+		q.lock.Unlock()
+
+		r.sink.write(r.level, r.data)
+
+		q.lock.Lock()
+		q.busy = false
+		q.cond.Broadcast()
+	}
+}
+
+// wait until every queued record is written or ctx is done
+func (q *logQueue) flush(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		q.lock.Lock()
+		q.cond.Broadcast()
+		q.lock.Unlock()
+	})
+	defer stop()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for (q.n > 0 || q.busy) && ctx.Err() == nil {
+		q.cond.Wait()
+	}
+
+	return ctx.Err()
+}
+
+// flush and stop the writer, later records are written directly
+func (q *logQueue) close(ctx context.Context) error {
+	err := q.flush(ctx)
+
+	q.lock.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.lock.Unlock()
+
+	if err == nil {
+		<-q.done
+	}
+	return err
+}
+
+// render line as the sink logger would write it, for the queue. depth is
+// the Output depth of the caller.
+func (s *logSink) render(depth int, line string) []byte {
+	var buf bytes.Buffer
+	log.New(&buf, "", s.logger.Flags()).Output(depth+1, line)
+	return buf.Bytes()
+}
+
+// Dropped returns the number of records an async log dropped because its
+// queue was full
+func (l *BaseLog) Dropped() uint64 {
+	root := l.base()
+	root.lock.Lock()
+	defer root.lock.Unlock()
+
+	return root.dropped + root.queueDropped()
+}
+
+// drops of the running queue, must hold lock
+func (l *BaseLog) queueDropped() uint64 {
+	if l.queue == nil {
+		return 0
+	}
+	return l.queue.dropped.Load()
+}
+
+// Flush waits until an async log has written the queued records or ctx
+// is done. It returns at once for a synchronous log.
+func (l *BaseLog) Flush(ctx context.Context) error {
+	root := l.base()
+	root.lock.Lock()
+	q := root.queue
+	root.lock.Unlock()
+
+	if q == nil {
+		return nil
+	}
+	return q.flush(ctx)
+}
+
+// switch the async queue on or off for opt, must hold lock. The stopped
+// queue is returned for the caller to close outside the lock.
+func (l *BaseLog) setQueue(opt *LogOptions) *logQueue {
+	if !opt.Async {
+		old := l.queue
+		if old != nil {
+			l.dropped += old.dropped.Load()
+		}
+		l.queue = nil
+		return old
+	}
+
+	if l.queue == nil {
+		l.queue = newLogQueue(opt.QueueSize, opt.QueueFull)
+		return nil
+	}
+
+	l.queue.lock.Lock()
+	l.queue.policy = opt.QueueFull
+	l.queue.lock.Unlock()
+	return nil
+}
+
+// flush the default log before the process exits
+func flushLog() {
+	if Log == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	Log.Flush(ctx)
+}
@@ -0,0 +1,99 @@
+package ebase
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a writer blocking until released
+type gateWriter struct {
+	lock sync.Mutex
+	gate chan struct{}
+	data strings.Builder
+}
+
+func (w *gateWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.data.Write(p)
+}
+
+func (w *gateWriter) String() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.data.String()
+}
+
+func TestLogQueueDropLowest(t *testing.T) {
+	w := &gateWriter{gate: make(chan struct{})}
+	s, _ := testSink("", LevelTrace, LogFormatText, 0)
+	s.out = w
+	q := newLogQueue(2, QueueDropLowest)
+
+	// the writer takes the first record and blocks
+	q.push(logRecord{sink: s, level: LevelInfo, data: []byte("info1\n")})
+	for {
+		q.lock.Lock()
+		busy := q.busy
+		q.lock.Unlock()
+		if busy {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	q.push(logRecord{sink: s, level: LevelDebug, data: []byte("debug\n")})
+	q.push(logRecord{sink: s, level: LevelInfo, data: []byte("info2\n")})
+	q.push(logRecord{sink: s, level: LevelError, data: []byte("error\n")}) // drops debug
+	q.push(logRecord{sink: s, level: LevelTrace, data: []byte("trace\n")}) // dropped itself
+
+	close(w.gate)
+	if err := q.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.String(); got != "info1\ninfo2\nerror\n" {
+		t.Errorf("written %q", got)
+	}
+	if n := q.dropped.Load(); n != 2 {
+		t.Errorf("dropped %d", n)
+	}
+}
+
+func TestLogAsync(t *testing.T) {
+	l, buf := testLog(LogFormatText, 0)
+	var lock sync.Mutex
+	l.sinks[0].out = lockedWriter{&lock, buf}
+	l.setQueue(&LogOptions{Async: true, QueueSize: 4})
+
+	for i := 0; i < 100; i++ {
+		l.Infow("line", "n", i)
+	}
+	if err := l.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	lock.Unlock()
+	if len(lines) != 100 || lines[99] != "[Inf] line n=99" || l.Dropped() != 0 {
+		t.Errorf("%d lines, last %q, dropped %d", len(lines), lines[len(lines)-1], l.Dropped())
+	}
+
+	l.Close()
+	l.Info("after close") // no queue, no sinks
+}
+
+type lockedWriter struct {
+	lock *sync.Mutex
+	w    interface{ Write([]byte) (int, error) }
+}
+
+func (w lockedWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.w.Write(p)
+}
@@ -0,0 +1,261 @@
+package ebase
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// log line formats, LogOptions.Format
+const (
+	LogFormatText   = "text"   // [Inf] message user=42, the log flags prefix it
+	LogFormatJSON   = "json"   // {"time":"...","level":"info","msg":"message","user":42}
+	LogFormatLogfmt = "logfmt" // time=... level=info msg=message user=42
+)
+
+// Println and Printf lines have no level
+const levelNone = -1
+
+var levelPrefix = []string{"[Crt] ", "[Err] ", "[War] ", "[Inf] ", "[Dbg] ", "[Trc] "}
+var levelName = []string{"critical", "error", "warning", "info", "debug", "trace"}
+
+func logFormat(opt *LogOptions) string {
+	switch opt.Format {
+	case LogFormatJSON, LogFormatLogfmt:
+		return opt.Format
+	}
+	return LogFormatText
+}
+
+// the Loger writes json and logfmt lines as they are
+func loggerFlag(opt *LogOptions) int {
+	if logFormat(opt) != LogFormatText {
+		return 0
+	}
+	return opt.Flag
+}
+
+func (l *BaseLog) base() *BaseLog {
+	if l.root != nil {
+		return l.root
+	}
+	return l
+}
+
+// With returns a logger adding the key-value pairs to every line, it
+// writes through l and follows its Reopen.
+//
+//	reqLog := Log.With("request", id)
+//	reqLog.Infow("done", "latency", d) // [Inf] done request=7 latency=12ms
+func (l *BaseLog) With(fields ...interface{}) *BaseLog {
+	root := l.base()
+	kv := make([]interface{}, 0, len(l.fields)+len(fields))
+	kv = append(append(kv, l.fields...), fields...)
+
+	return &BaseLog{Loger: root.Loger, LogFile: root.LogFile, LogLevel: root.LogLevel,
+		LogType: root.LogType, root: root, fields: kv}
+}
+
+// Level returns the level of the main output
+func (l *BaseLog) Level() int {
+	root := l.base()
+	root.lock.Lock()
+	defer root.lock.Unlock()
+
+	return root.LogLevel
+}
+
+// the arguments of Info and the like are printed like fmt.Sprintln
+func (l *BaseLog) print(level int, v []interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	l.output(level, fmt.Sprintln(v...), nil)
+}
+
+// a message with key-value pairs, Infow and the like
+func (l *BaseLog) printw(level int, msg string, kv []interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	l.output(level, msg, kv)
+}
+
+func (l *BaseLog) printf(level int, format string, v []interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	l.output(level, fmt.Sprintf(format, v...), nil)
+}
+
+// whether any sink takes level
+func (l *BaseLog) enabled(level int) bool {
+	return int(l.base().maxLevel.Load()) >= level
+}
+
+// caller depth of output for the Loger: output, print or printw, the
+// BaseLog method
+const outputDepth = 4
+
+// write one line to every sink taking level, in the format of the sink
+func (l *BaseLog) output(level int, msg string, kv []interface{}) {
+	root := l.base()
+	root.lock.Lock()
+	sinks, q := root.sinks, root.queue
+	root.lock.Unlock()
+
+	if len(l.fields) > 0 {
+		kv = append(l.fields[:len(l.fields):len(l.fields)], kv...)
+	}
+
+	depth := outputDepth
+	if level == levelNone {
+		depth-- // Println and Printf call output directly
+	}
+
+	for _, s := range sinks {
+		if level != levelNone && s.level < level {
+			continue
+		}
+
+		var line string
+		switch s.format {
+		case LogFormatJSON:
+			line = encodeJSON(level, msg, kv, caller(depth, s.flag))
+		case LogFormatLogfmt:
+			line = encodeLogfmt(level, msg, kv, caller(depth, s.flag))
+		default:
+			line = msg
+			if len(kv) > 0 {
+				line = strings.TrimSuffix(line, "\n") + " " + logfmtPairs(kv)
+			}
+			if level != levelNone {
+				line = levelPrefix[level] + line
+			}
+		}
+
+		if q != nil && q.push(logRecord{sink: s, level: level, data: s.render(depth, line)}) {
+			continue
+		}
+		if _, ok := s.out.(levelWriter); ok {
+			s.write(level, s.render(depth, line))
+			continue
+		}
+		s.logger.Output(depth, line)
+	}
+}
+
+// file:line of the logging call when the flags ask for it
+func caller(depth, flag int) string {
+	if flag&(log.Lshortfile|log.Llongfile) == 0 {
+		return ""
+	}
+	_, file, line, ok := runtime.Caller(depth)
+	if !ok {
+		return "???:0"
+	}
+	if flag&log.Lshortfile != 0 {
+		file = file[strings.LastIndexByte(file, '/')+1:]
+	}
+	return file + ":" + strconv.Itoa(line)
+}
+
+func encodeJSON(level int, msg string, kv []interface{}, src string) string {
+	var b strings.Builder
+	b.WriteString(`{"time":`)
+	writeJSON(&b, time.Now().Format(time.RFC3339Nano))
+	if level != levelNone {
+		b.WriteString(`,"level":`)
+		writeJSON(&b, levelName[level])
+	}
+	if src != "" {
+		b.WriteString(`,"caller":`)
+		writeJSON(&b, src)
+	}
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, strings.TrimSuffix(msg, "\n"))
+
+	for i := 0; i < len(kv); i += 2 {
+		key, value := pair(kv, i)
+		b.WriteByte(',')
+		writeJSON(&b, key)
+		b.WriteByte(':')
+		writeJSON(&b, logValue(value))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+func writeJSON(b *strings.Builder, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+func encodeLogfmt(level int, msg string, kv []interface{}, src string) string {
+	pairs := []interface{}{"time", time.Now().Format(time.RFC3339Nano)}
+	if level != levelNone {
+		pairs = append(pairs, "level", levelName[level])
+	}
+	if src != "" {
+		pairs = append(pairs, "caller", src)
+	}
+	pairs = append(pairs, "msg", strings.TrimSuffix(msg, "\n"))
+
+	return logfmtPairs(append(pairs, kv...))
+}
+
+// key=value pairs, values with spaces, quotes or = are quoted
+func logfmtPairs(kv []interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(kv); i += 2 {
+		key, value := pair(kv, i)
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+
+		s := fmt.Sprint(logValue(value))
+		if s == "" || strings.ContainsAny(s, " =\"\t\r\n\\") || !utf8.ValidString(s) {
+			s = strconv.Quote(s)
+		}
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+// the key and value at i, an odd trailing value gets the key !BADKEY
+func pair(kv []interface{}, i int) (string, interface{}) {
+	if i+1 >= len(kv) {
+		return "!BADKEY", kv[i]
+	}
+	key, ok := kv[i].(string)
+	if !ok {
+		key = fmt.Sprint(kv[i])
+	}
+	return key, kv[i+1]
+}
+
+// errors, durations and Stringers are logged as text
+func logValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
@@ -0,0 +1,125 @@
+package ebase
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSink(name string, level int, format string, flag int) (*logSink, *bytes.Buffer) {
+	var buf bytes.Buffer
+	opt := &LogOptions{Format: format, Flag: flag}
+	return &logSink{name: name, level: level, format: logFormat(opt), flag: flag,
+		out: &buf, logger: log.New(&buf, "", loggerFlag(opt))}, &buf
+}
+
+func testLog(format string, flag int) (*BaseLog, *bytes.Buffer) {
+	s, buf := testSink("", LevelDebug, format, flag)
+	l := &BaseLog{Loger: s.logger, LogLevel: s.level, sinks: []*logSink{s}}
+	l.maxLevel.Store(int32(s.level))
+	return l, buf
+}
+
+func TestLogText(t *testing.T) {
+	l, buf := testLog(LogFormatText, 0)
+
+	l.Info("plain", 42)
+	// pairs only with Infow, Info prints its arguments
+	l.Info("user", "bob", "logged in")
+	l.Infow("request done", "user", 42, "latency", 1500*time.Millisecond)
+	l.Infow("no pairs")
+	l.Info()
+	l.With("req", "a b").Warn("slow")
+	l.Trace("hidden")
+	l.Errorf("code %d", 7)
+
+	want := "[Inf] plain 42\n" +
+		"[Inf] user bob logged in\n" +
+		"[Inf] request done user=42 latency=1.5s\n" +
+		"[Inf] no pairs\n" +
+		"[Inf] \n" +
+		"[War] slow req=\"a b\"\n" +
+		"[Err] code 7\n"
+	if buf.String() != want {
+		t.Errorf("text\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	l, buf := testLog(LogFormatJSON, log.Lshortfile)
+
+	l.With("service", "api").Errorw("failed", "err", errors.New("boom"), "n", 3)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%s: %s", buf.String(), err)
+	}
+	if line["level"] != "error" || line["msg"] != "failed" || line["service"] != "api" ||
+		line["err"] != "boom" || line["n"] != 3.0 {
+		t.Errorf("json %s", buf.String())
+	}
+	if caller, _ := line["caller"].(string); !strings.HasPrefix(caller, "logformat_test.go:") {
+		t.Errorf("caller %q", caller)
+	}
+}
+
+func TestLogLogfmt(t *testing.T) {
+	l, buf := testLog(LogFormatLogfmt, 0)
+
+	l.Debugw("cache miss", "key", "user=1")
+
+	line := buf.String()
+	if !strings.HasPrefix(line, "time=") ||
+		!strings.HasSuffix(line, " level=debug msg=\"cache miss\" key=\"user=1\"\n") {
+		t.Errorf("logfmt %q", line)
+	}
+}
+
+func TestLogSinks(t *testing.T) {
+	main, mainBuf := testSink("", LevelDebug, LogFormatText, 0)
+	errs, errBuf := testSink("errors", LevelError, LogFormatJSON, 0)
+	l := &BaseLog{Loger: main.logger, LogLevel: main.level, sinks: []*logSink{main, errs}}
+	l.maxLevel.Store(int32(maxSinkLevel(l.sinks)))
+
+	l.Debug("cache miss")
+	l.Errorw("db down", "host", "db1")
+	l.Trace("hidden")
+
+	if want := "[Dbg] cache miss\n[Err] db down host=db1\n"; mainBuf.String() != want {
+		t.Errorf("main %q, want %q", mainBuf.String(), want)
+	}
+	if lines := strings.Count(errBuf.String(), "\n"); lines != 1 ||
+		!strings.Contains(errBuf.String(), `"msg":"db down","host":"db1"}`) {
+		t.Errorf("errors sink %q", errBuf.String())
+	}
+}
+
+func TestConfigLogSinks(t *testing.T) {
+	cfg := NewConfiguration(NewMapSource(LayerFile, map[string]string{
+		"log.level":              "4",
+		"log.sink.errors.type":   "file",
+		"log.sink.errors.file":   "var/error.log",
+		"log.sink.errors.level":  "1",
+		"log.sink.errors.format": "json",
+		"log.sink.syslog.level":  "0",
+		"log.sink.syslog.type":   "syslog",
+	}))
+
+	opt, err := configLogOptions(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Level != 4 || len(opt.Sinks) != 2 {
+		t.Fatalf("options %+v", opt)
+	}
+	if s := opt.Sinks["errors"]; s.Type != "file" || s.Level != LevelError || s.Format != LogFormatJSON {
+		t.Errorf("errors sink %+v", s)
+	}
+	if err = cfg.Check(nil); err != nil {
+		t.Errorf("check: %s", err)
+	}
+}
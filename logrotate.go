@@ -0,0 +1,335 @@
+package ebase
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotation schedules, LogOptions.Rotate
+const (
+	RotateDaily  = "daily"
+	RotateHourly = "hourly"
+)
+
+// time suffix of a rotated file, <file>.<time>[.n][.gz]
+const rotateTimeFormat = "20060102-150405"
+
+// RotateWriter is a log file rotated by size and/or schedule. A rotated
+// file is renamed to <file>.<time>, gzipped in the background when
+// Compress is set, and old files beyond MaxFiles or MaxAge are removed.
+type RotateWriter struct {
+	File     string        // made absolute at open
+	MaxSize  int64         // rotate when the file would grow past this, 0 never
+	Schedule string        // RotateDaily, RotateHourly or "" for none
+	MaxFiles int           // rotated files kept, 0 all
+	MaxAge   time.Duration // rotated files older than this are removed, 0 never
+	Compress bool
+
+	lock sync.Mutex
+	f    *os.File
+	size int64
+	next time.Time // next scheduled rotation
+	wg   sync.WaitGroup
+	bg   sync.Mutex // serializes compression and cleanup
+	now  func() time.Time
+
+	// another process writing the file rotates it, reopen when it moved
+	follow bool
+}
+
+// OpenRotateWriter opens w.File for appending, creating it if needed
+func OpenRotateWriter(w *RotateWriter) (*RotateWriter, error) {
+	if w.now == nil {
+		w.now = time.Now
+	}
+	file, err := filepath.Abs(w.File)
+	if err != nil {
+		return nil, err
+	}
+	w.File = file
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	w.next = w.nextRotation(w.now())
+
+	return w, nil
+}
+
+// rotate options of the file log
+func newRotateWriter(opt *LogOptions) (*RotateWriter, error) {
+	return OpenRotateWriter(&RotateWriter{
+		File:     opt.File,
+		MaxSize:  int64(opt.MaxSize) << 20,
+		Schedule: opt.Rotate,
+		MaxFiles: opt.MaxFiles,
+		MaxAge:   time.Duration(opt.MaxAge) * 24 * time.Hour,
+		Compress: opt.Compress,
+	})
+}
+
+// must hold lock
+func (w *RotateWriter) open() error {
+	f, err := os.OpenFile(w.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.f = f
+	w.size = fi.Size()
+	return nil
+}
+
+func (w *RotateWriter) nextRotation(now time.Time) time.Time {
+	switch w.Schedule {
+	case RotateDaily:
+		y, m, d := now.Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+	case RotateHourly:
+		y, m, d := now.Date()
+		return time.Date(y, m, d, now.Hour()+1, 0, 0, 0, now.Location())
+	}
+	return time.Time{}
+}
+
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	now := w.now()
+	if w.follow {
+		if !sameFile(w.f, w.File) {
+			w.f.Close()
+			if err := w.open(); err != nil {
+				w.f = nil
+				return 0, err
+			}
+		}
+	} else if (!w.next.IsZero() && !now.Before(w.next)) ||
+		(w.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.MaxSize) {
+		if err := w.rotate(now); err != nil {
+			fmt.Fprintf(os.Stderr, "rotate log %s error: %s\n", w.File, err)
+		}
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file now
+func (w *RotateWriter) Rotate() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.f == nil {
+		return os.ErrClosed
+	}
+	return w.rotate(w.now())
+}
+
+// must hold lock
+func (w *RotateWriter) rotate(now time.Time) error {
+	w.next = w.nextRotation(now)
+
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f = nil
+
+	name := w.File + "." + now.Format(rotateTimeFormat)
+	for i := 1; IsExist(name) || IsExist(name+".gz"); i++ {
+		name = fmt.Sprintf("%s.%s.%d", w.File, now.Format(rotateTimeFormat), i)
+	}
+	err := os.Rename(w.File, name)
+	if e := w.open(); e != nil {
+		return e
+	}
+	if err != nil {
+		return err
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.bg.Lock()
+		defer w.bg.Unlock()
+
+		if w.Compress {
+			// may be removed already by a later cleanup
+			if err := gzipFile(name); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "compress log %s error: %s\n", name, err)
+			}
+		}
+		w.removeOld()
+	}()
+
+	return nil
+}
+
+// Reopen closes and opens the file by name, for external tools that move
+// the file away
+func (w *RotateWriter) Reopen() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.f != nil {
+		w.f.Close()
+	}
+	return w.open()
+}
+
+// Close closes the file and waits for background compression
+func (w *RotateWriter) Close() error {
+	w.lock.Lock()
+	var err error
+	if w.f != nil {
+		err = w.f.Close()
+		w.f = nil
+	}
+	w.lock.Unlock()
+
+	w.wg.Wait()
+	return err
+}
+
+// gzip file to file.gz and remove it
+func gzipFile(file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(file+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(file + ".gz")
+		return err
+	}
+
+	return os.Remove(file)
+}
+
+// rotated files of w.File, compressed or not
+func (w *RotateWriter) rotated() []os.FileInfo {
+	dir, base := filepath.Split(w.File)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	// only the names rotate gives, not app.log.err or app.log.bak
+	name := regexp.MustCompile(`^` + regexp.QuoteMeta(base) + `\.\d{8}-\d{6}(\.\d+)?(\.gz)?$`)
+
+	var files []os.FileInfo
+	for _, e := range entries {
+		m := name.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		// a file being compressed is counted once
+		if m[2] != "" && IsExist(filepath.Join(dir, e.Name()[:len(e.Name())-len(".gz")])) {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			files = append(files, fi)
+		}
+	}
+
+	return files
+}
+
+// remove files older than MaxAge, then the oldest beyond MaxFiles. The
+// names sort by rotation time.
+func (w *RotateWriter) removeOld() {
+	if w.MaxFiles <= 0 && w.MaxAge <= 0 {
+		return
+	}
+
+	dir := filepath.Dir(w.File)
+	files := w.rotated()
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() > files[j].Name() })
+
+	for i, fi := range files {
+		if (w.MaxFiles > 0 && i >= w.MaxFiles) || (w.MaxAge > 0 && w.now().Sub(fi.ModTime()) > w.MaxAge) {
+			Unlink(filepath.Join(dir, fi.Name()))
+		}
+	}
+}
+
+// leave the rotation of the log files to another process writing them,
+// like the worker of a supervisor, and reopen a file once it moved
+func (l *BaseLog) followFiles() {
+	l = l.base()
+	l.lock.Lock()
+	sinks := l.sinks
+	l.lock.Unlock()
+
+	for _, s := range sinks {
+		if w, ok := s.out.(*RotateWriter); ok {
+			w.lock.Lock()
+			w.follow = true
+			w.lock.Unlock()
+		}
+	}
+}
+
+// reopen the file log, for logrotate and other tools moving the file
+func ReopenLogSignal(sig os.Signal) error {
+	if Log == nil {
+		return nil
+	}
+	return Log.ReopenFile()
+}
+
+// ReopenFile reopens the log files by name, other log types are left as
+// they are
+func (l *BaseLog) ReopenFile() error {
+	l = l.base()
+	l.lock.Lock()
+	sinks := l.sinks
+	l.lock.Unlock()
+
+	var err error
+	for _, s := range sinks {
+		if w, ok := s.out.(*RotateWriter); ok {
+			if e := w.Reopen(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
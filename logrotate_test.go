@@ -0,0 +1,138 @@
+package ebase
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func logFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRotateWriterSize(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 17, 10, 0, 0, 0, time.Local)
+	w, err := OpenRotateWriter(&RotateWriter{File: filepath.Join(dir, "app.log"),
+		MaxSize: 10, MaxFiles: 2, Compress: true,
+		now: func() time.Time { now = now.Add(time.Second); return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err = w.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "app.log app.log.20261017-100004.gz app.log.20261017-100005.gz"
+	if got := strings.Join(logFiles(t, dir), " "); got != want {
+		t.Errorf("files %s, want %s", got, want)
+	}
+}
+
+func TestRotateWriterSchedule(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 17, 23, 59, 0, 0, time.Local)
+	w, err := OpenRotateWriter(&RotateWriter{File: filepath.Join(dir, "app.log"),
+		Schedule: RotateDaily, now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("day one\n"))
+	now = now.Add(2 * time.Minute)
+	w.Write([]byte("day two\n"))
+
+	data, _ := os.ReadFile(filepath.Join(dir, "app.log.20261018-000100"))
+	if string(data) != "day one\n" {
+		t.Errorf("rotated %q, files %v", data, logFiles(t, dir))
+	}
+
+	// moved away by an external tool
+	os.Rename(filepath.Join(dir, "app.log"), filepath.Join(dir, "moved.log"))
+	if err = w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("after\n"))
+	if data, _ = os.ReadFile(filepath.Join(dir, "app.log")); string(data) != "after\n" {
+		t.Errorf("reopened %q", data)
+	}
+}
+
+func TestRotateWriterOthers(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+
+	// files sharing the name but not rotated by the writer are kept
+	for _, name := range []string{"app.log.err", "app.log.bak", "app.log.20261017-0900.gz", "app.log.20261017-090000"} {
+		os.WriteFile(name, nil, 0644)
+	}
+	now := time.Date(2026, 10, 17, 10, 0, 0, 0, time.Local)
+	w, err := OpenRotateWriter(&RotateWriter{File: "app.log", MaxSize: 10, MaxFiles: 1,
+		now: func() time.Time { now = now.Add(time.Second); return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.File != filepath.Join(dir, "app.log") {
+		t.Errorf("file %s", w.File)
+	}
+
+	// the path stays valid after a daemon changes dir
+	os.Chdir("/")
+	for i := 0; i < 3; i++ {
+		w.Write([]byte("0123456789"))
+	}
+	w.Close()
+
+	want := "app.log app.log.20261017-0900.gz app.log.20261017-100004 app.log.bak app.log.err"
+	if got := strings.Join(logFiles(t, dir), " "); got != want {
+		t.Errorf("files %s, want %s", got, want)
+	}
+}
+
+func TestRotateWriterFollow(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.log")
+	master, err := OpenRotateWriter(&RotateWriter{File: file, MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	l := &BaseLog{sinks: []*logSink{{out: master}}}
+	l.followFiles()
+
+	// the master leaves the rotation to the worker
+	master.Write([]byte("master 1\n"))
+	master.Write([]byte("master 2\n"))
+	if files := logFiles(t, dir); len(files) != 1 {
+		t.Fatalf("master rotated: %v", files)
+	}
+
+	// and writes to the new file once the worker rotated
+	os.Rename(file, file+".20261017-100000")
+	master.Write([]byte("master 3\n"))
+	if data, _ := os.ReadFile(file); string(data) != "master 3\n" {
+		t.Errorf("after rotation %q", data)
+	}
+}
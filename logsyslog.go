@@ -0,0 +1,261 @@
+package ebase
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslog facilities by name, LogOptions.Facility
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslog severity of each level: crit, err, warning, info, debug, debug
+var syslogSeverity = []int{2, 3, 4, 6, 7, 7}
+
+// sockets of the local syslog daemon
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// a writer taking the level of each line, the sink passes it on
+type levelWriter interface {
+	WriteLevel(level int, p []byte) (int, error)
+}
+
+// SyslogWriter writes lines to syslog with the severity of their level.
+// Without Network it writes to the local daemon in its traditional
+// format, with "udp", "tcp" or "tls" it sends RFC 5424 messages to Addr,
+// framed by octet counting over tcp and tls. A line the syslog does not
+// take within Timeout is dropped, the next one reconnects.
+type SyslogWriter struct {
+	Network   string        // "", "udp", "tcp" or "tls"
+	Addr      string        // host:port of a remote syslog
+	Facility  string        // facility name, default user
+	Tag       string        // app name, default the program name
+	Hostname  string        // host name sent to a remote syslog, default os.Hostname
+	Timeout   time.Duration // dial and write timeout, default 10s
+	TLSConfig *tls.Config
+
+	lock     sync.Mutex
+	facility int
+	conn     net.Conn
+	stream   bool // local stream socket, lines end with \n
+	closed   bool
+}
+
+// DialSyslog connects w to the local or remote syslog
+func DialSyslog(w *SyslogWriter) (*SyslogWriter, error) {
+	if w.Facility == "" {
+		w.Facility = "user"
+	}
+	facility, ok := syslogFacilities[w.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %s", w.Facility)
+	}
+	w.facility = facility
+
+	if w.Tag == "" {
+		w.Tag = filepath.Base(os.Args[0])
+	}
+	if w.Hostname == "" {
+		w.Hostname, _ = os.Hostname()
+	}
+	if w.Timeout <= 0 {
+		w.Timeout = 10 * time.Second
+	}
+
+	switch w.Network {
+	case "", "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("unknown syslog network %s", w.Network)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// syslog options of the log
+func newSyslogWriter(opt *LogOptions) (*SyslogWriter, error) {
+	w := &SyslogWriter{Network: opt.Network, Addr: opt.Addr, Facility: opt.Facility, Tag: opt.Tag}
+	if opt.Network == "tls" {
+		config, err := syslogTLSConfig(opt)
+		if err != nil {
+			return nil, err
+		}
+		w.TLSConfig = config
+	}
+	return DialSyslog(w)
+}
+
+// tls config from the tls_ca, tls_cert and tls_key options
+func syslogTLSConfig(opt *LogOptions) (*tls.Config, error) {
+	config := new(tls.Config)
+	if opt.TLSCA != "" {
+		pem, err := os.ReadFile(opt.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", opt.TLSCA)
+		}
+	}
+	if opt.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(opt.TLSCert, opt.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// must hold lock
+func (w *SyslogWriter) connect() error {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	var err error
+	switch w.Network {
+	case "":
+		for _, network := range []string{"unixgram", "unix"} {
+			for _, path := range syslogSockets {
+				if w.conn, err = net.Dial(network, path); err == nil {
+					w.stream = network == "unix"
+					return nil
+				}
+			}
+		}
+		return errors.New("local syslog not available")
+	case "tls":
+		w.conn, err = tls.DialWithDialer(&net.Dialer{Timeout: w.Timeout}, "tcp", w.Addr, w.TLSConfig)
+	default:
+		w.conn, err = net.DialTimeout(w.Network, w.Addr, w.Timeout)
+	}
+	return err
+}
+
+// Write writes p with the info severity
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(LevelInfo, p)
+}
+
+// WriteLevel writes p with the severity of level, reconnecting once when
+// the connection broke. A write timing out drops p and the connection.
+func (w *SyslogWriter) WriteLevel(level int, p []byte) (int, error) {
+	severity := 6
+	if level >= 0 && level < len(syslogSeverity) {
+		severity = syslogSeverity[level]
+	}
+	msg := w.format(w.facility<<3|severity, strings.TrimRight(string(p), "\n"))
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if w.conn != nil {
+		err := w.write(msg)
+		if err == nil {
+			return len(p), nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// a stalled syslog would block the retry as long, and part of
+			// the frame may be sent
+			w.conn.Close()
+			w.conn = nil
+			return 0, err
+		}
+	}
+	if err := w.connect(); err != nil {
+		return 0, err
+	}
+	if err := w.write(msg); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// must hold lock
+func (w *SyslogWriter) write(msg []byte) error {
+	w.conn.SetWriteDeadline(time.Now().Add(w.Timeout))
+	_, err := w.conn.Write(msg)
+	return err
+}
+
+// the message for the network of w
+func (w *SyslogWriter) format(pri int, msg string) []byte {
+	if w.Network == "" {
+		line := fmt.Sprintf("<%d>%s %s[%d]: %s", pri, time.Now().Format(time.Stamp), w.Tag, os.Getpid(), msg)
+		if w.stream {
+			line += "\n"
+		}
+		return []byte(line)
+	}
+
+	// <pri>1 timestamp hostname app-name procid msgid structured-data msg
+	line := fmt.Sprintf("<%d>1 %s %s %s %d - - %s", pri,
+		time.Now().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogField(w.Hostname, 255), syslogField(w.Tag, 48), os.Getpid(), msg)
+	if w.Network == "udp" {
+		return []byte(line)
+	}
+	return []byte(fmt.Sprintf("%d %s", len(line), line))
+}
+
+// a header field is printable ascii without spaces, "-" when empty
+func syslogField(s string, max int) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		if s[i] > ' ' && s[i] < 127 {
+			b = append(b, s[i])
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+// Close closes the connection
+func (w *SyslogWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.closed = true
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// write a rendered line of level, with its severity when out takes one
+func (s *logSink) write(level int, p []byte) {
+	if lw, ok := s.out.(levelWriter); ok {
+		lw.WriteLevel(level, p)
+		return
+	}
+	s.out.Write(p)
+}
@@ -0,0 +1,234 @@
+package ebase
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var rfc5424 = regexp.MustCompile(`^<(\d+)>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}\S+ host app \d+ - - (.*)$`)
+
+// read octet counted messages from the first connection of ln
+func readFramed(t *testing.T, ln net.Listener, msgs chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		size, err := r.ReadString(' ')
+		if err != nil {
+			close(msgs)
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil {
+			t.Errorf("bad frame length %q", size)
+			close(msgs)
+			return
+		}
+		buf := make([]byte, n)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			close(msgs)
+			return
+		}
+		msgs <- string(buf)
+	}
+}
+
+func checkSyslog(t *testing.T, got, pri, msg string) {
+	t.Helper()
+	m := rfc5424.FindStringSubmatch(got)
+	if m == nil || m[1] != pri || m[2] != msg {
+		t.Errorf("got %q, want <%s> %q", got, pri, msg)
+	}
+}
+
+func syslogLog(t *testing.T, network, addr string) *BaseLog {
+	l, err := OpenLog(&LogOptions{Type: "syslog", Level: LevelDebug, Flag: 0, Format: LogFormatText,
+		Network: network, Addr: addr, Facility: "local3", Tag: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.sinks[0].out.(*SyslogWriter).Hostname = "host"
+	return l
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	msgs := make(chan string, 10)
+	go readFramed(t, ln, msgs)
+
+	l := syslogLog(t, "tcp", ln.Addr().String())
+	l.Critical("down")
+	l.Errorw("failed", "err", "eof")
+	l.Warn("slow")
+	l.Info("up")
+	l.Debug("detail")
+	l.Trace("hidden") // above the level
+	l.Close()
+
+	// local3 is 19, 19*8 = 152
+	want := []struct{ pri, msg string }{
+		{"154", "[Crt] down"}, {"155", "[Err] failed err=eof"}, {"156", "[War] slow"},
+		{"158", "[Inf] up"}, {"159", "[Dbg] detail"},
+	}
+	for _, w := range want {
+		checkSyslog(t, <-msgs, w.pri, w.msg)
+	}
+	if m, ok := <-msgs; ok {
+		t.Errorf("unexpected %q", m)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	l := syslogLog(t, "udp", pc.LocalAddr().String())
+	defer l.Close()
+	l.Warnw("disk", "free", "5%")
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSyslog(t, string(buf[:n]), "156", "[War] disk free=5%")
+}
+
+func TestSyslogTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "syslog"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	msgs := make(chan string, 1)
+	go readFramed(t, ln, msgs)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	w, err := DialSyslog(&SyslogWriter{Network: "tls", Addr: ln.Addr().String(), Tag: "app",
+		Hostname: "host", TLSConfig: &tls.Config{RootCAs: roots}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteLevel(LevelError, []byte("secure\n"))
+	w.Close()
+
+	// user facility
+	checkSyslog(t, <-msgs, "11", "secure")
+	if _, err = w.Write([]byte("closed")); err == nil {
+		t.Error("write after close")
+	}
+}
+
+func TestSyslogOptions(t *testing.T) {
+	if _, err := DialSyslog(&SyslogWriter{Network: "udp", Addr: "127.0.0.1:514", Facility: "bogus"}); err == nil {
+		t.Error("unknown facility accepted")
+	}
+	if _, err := DialSyslog(&SyslogWriter{Network: "sctp"}); err == nil {
+		t.Error("unknown network accepted")
+	}
+	if f := syslogField("my app\n", 48); f != "myapp" {
+		t.Errorf("field %q", f)
+	}
+	if f := syslogField("", 48); f != "-" {
+		t.Errorf("empty field %q", f)
+	}
+}
+
+func TestSyslogWriteTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// a syslog that accepts but never reads
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+		}
+	}()
+	defer func() {
+		ln.Close()
+		for len(conns) > 0 {
+			(<-conns).Close()
+		}
+	}()
+
+	w, err := DialSyslog(&SyslogWriter{Network: "tcp", Addr: ln.Addr().String(), Tag: "app",
+		Hostname: "host", Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	line := []byte(strings.Repeat("x", 64<<10))
+	start := time.Now()
+	for i := 0; ; i++ {
+		if _, err = w.Write(line); err != nil {
+			break
+		}
+		if i == 10000 {
+			t.Fatal("writes never blocked")
+		}
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) || time.Since(start) > 10*time.Second {
+		t.Fatalf("write error %v after %s", err, time.Since(start))
+	}
+
+	// the stalled connection is dropped, the next write reconnects
+	<-conns
+	w.Write([]byte("again"))
+	select {
+	case c := <-conns:
+		c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reconnect")
+	}
+}
@@ -9,21 +9,34 @@ package ebase
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net/mail"
 	"net/smtp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // SMTP setup
 type Smtp struct {
-	SmtpUserName                     string
-	SmtpHost, SmtpUser, SmtpPassword string
-	SmtpPort                         int
-	SmtpAuth, SmtpTLS, SmtpDaemon    bool
-	mailChan                         chan *Mailer
+	SmtpUserName string `cfg:"username"`
+	SmtpHost     string `cfg:"host"`
+	SmtpUser     string `cfg:"user"`
+	SmtpPassword string `cfg:"password"`
+	SmtpPort     int    `cfg:"port" default:"25"`
+	SmtpAuth     bool   `cfg:"auth"`
+	SmtpTLS      bool   `cfg:"tls"`
+	SmtpDaemon   bool   `cfg:"daemon"`
+	mailChan     chan *Mailer
+
+	lock    sync.RWMutex
+	optLock sync.RWMutex
+	closed  bool
+	running atomic.Bool
+	done    chan struct{}
 }
 
 type Mailer struct {
@@ -35,27 +48,57 @@ type Mailer struct {
 func NewSmtp() *Smtp {
 	// SMTP
 	s := new(Smtp)
-	s.SmtpHost, _ = Config.String("smtp.host", "")
-	s.SmtpUser, _ = Config.String("smtp.user", "")
-	s.SmtpPassword, _ = Config.String("smtp.password", "")
-	s.SmtpPort, _ = Config.Int("smtp.port", 25)
-	s.SmtpAuth, _ = Config.Bool("smtp.auth", false)
-	s.SmtpTLS, _ = Config.Bool("smtp.tls", false)
-	s.SmtpDaemon, _ = Config.Bool("smtp.daemon", false)
+	s.loadConfig()
 
 	s.mailChan = make(chan *Mailer)
+	s.done = make(chan struct{})
+
+	RegisterShutdown("smtp", ShutdownDefault, s.Close)
+	Reloader.Subscribe("smtp", func(old, new ConfigSection) error {
+		s.loadConfig()
+		return nil
+	})
 
 	return s
 }
 
+// read the smtp section of the global Config
+func (s *Smtp) loadConfig() {
+	s.optLock.Lock()
+	defer s.optLock.Unlock()
+
+	if err := BindConfig("smtp", s); err != nil && Log != nil {
+		Log.Error(err)
+	}
+}
+
+// copy of the settings, safe against a concurrent reload
+func (s *Smtp) options() Smtp {
+	s.optLock.RLock()
+	defer s.optLock.RUnlock()
+
+	return Smtp{SmtpUserName: s.SmtpUserName, SmtpHost: s.SmtpHost,
+		SmtpUser: s.SmtpUser, SmtpPassword: s.SmtpPassword, SmtpPort: s.SmtpPort,
+		SmtpAuth: s.SmtpAuth, SmtpTLS: s.SmtpTLS, SmtpDaemon: s.SmtpDaemon}
+}
+
 // 运行一个goroutine 监听发送邮件任务
+// returns after Close once the queued mails are sent, at once when a
+// server runs already
 func (s *Smtp) MailSendServer() {
 	//    mailChan = make(chan *Mailer)
+	if s.running.CompareAndSwap(false, true) {
+		s.serve()
+	}
+}
+
+// the MailSendServer loop, run by whoever set running
+func (s *Smtp) serve() {
 	Log.Info("Running Mail Send Server...")
 
-	for {
+	defer close(s.done)
 
-		mailer := <-s.mailChan
+	for mailer := range s.mailChan {
 
 		if mailer == nil {
 			continue
@@ -67,6 +110,44 @@ func (s *Smtp) MailSendServer() {
 		}
 	}
 
+	Log.Info("Mail Send Server stopped")
+}
+
+// Start runs MailSendServer, so the Smtp is an App Component. Mails sent
+// once it returns are queued, a second Start does nothing
+func (s *Smtp) Start(ctx context.Context) error {
+	if s.running.CompareAndSwap(false, true) {
+		go s.serve()
+	}
+	return nil
+}
+
+// Stop is Close
+func (s *Smtp) Stop(ctx context.Context) error {
+	return s.Close(ctx)
+}
+
+// stop accepting mails and wait for MailSendServer to drain the queue
+func (s *Smtp) Close(ctx context.Context) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.mailChan)
+	s.lock.Unlock()
+
+	if !s.running.Load() {
+		return nil
+	}
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (s *Smtp) MailSender(subject, content, to string, args ...string) (err error) {
@@ -81,22 +162,40 @@ func (s *Smtp) MailSender(subject, content, to string, args ...string) (err erro
 
 	if subject != "" && content != "" && to != "" {
 		m := &Mailer{Subject: subject, Content: content, To: to, Cc: cc, Bcc: bcc}
-		if s.SmtpDaemon {
-			s.mailChan <- m
-		} else {
-			send := s.NewMailMessage(m)
-			if err = send.Send(); err != nil {
-				Log.Errorf("send mail to "+to+" error %s", err)
+		if s.options().SmtpDaemon {
+			if queued, err := s.enqueue(m); queued || err != nil {
 				return err
 			}
 		}
 
+		// without a running MailSendServer the mail is sent now
+		send := s.NewMailMessage(m)
+		if err = send.Send(); err != nil {
+			Log.Errorf("send mail to "+to+" error %s", err)
+			return err
+		}
+
 		return nil
 	}
 
 	return errors.New("input is null")
 }
 
+// hand m to MailSendServer, false when no server runs
+func (s *Smtp) enqueue(m *Mailer) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.closed {
+		return false, errors.New("mail server closed")
+	}
+	if !s.running.Load() {
+		return false, nil
+	}
+	s.mailChan <- m
+	return true, nil
+}
+
 func (s *Smtp) NewMailMessage(m *Mailer) *MailMessage {
 	tos := strings.Split(m.To, ",")
 	ccs := strings.Split(m.Cc, ",")
@@ -164,7 +263,8 @@ func (self *MailMessage) String() string {
 
 	from := &self.From
 	if from.Address == "" {
-		from = &mail.Address{self.S.SmtpUserName, self.S.SmtpUser} //&Config.From
+		opt := self.S.options()
+		from = &mail.Address{Name: opt.SmtpUserName, Address: opt.SmtpUser} //&Config.From
 	}
 
 	//if cfg.adminMail != "" {
@@ -210,19 +310,20 @@ func (self *MailMessage) Send() error {
 		to[i] = self.To[i].Address
 	}
 
+	opt := self.S.options()
 	from := self.From.Address
 	if from == "" {
-		from = self.S.SmtpUser // Config.From.Address
+		from = opt.SmtpUser // Config.From.Address
 	}
 
-	addr := fmt.Sprintf("%s:%d", self.S.SmtpHost, self.S.SmtpPort)
+	addr := fmt.Sprintf("%s:%d", opt.SmtpHost, opt.SmtpPort)
 
-	if self.S.SmtpTLS {
-		auth = fakeAuth{smtp.PlainAuth("", self.S.SmtpUser,
-			self.S.SmtpPassword, self.S.SmtpHost)}
+	if opt.SmtpTLS {
+		auth = fakeAuth{smtp.PlainAuth("", opt.SmtpUser,
+			opt.SmtpPassword, opt.SmtpHost)}
 	} else {
-		auth = smtp.PlainAuth("", self.S.SmtpUser,
-			self.S.SmtpPassword, self.S.SmtpHost)
+		auth = smtp.PlainAuth("", opt.SmtpUser,
+			opt.SmtpPassword, opt.SmtpHost)
 	}
 
 	return smtp.SendMail(addr, auth, from, to, []byte(self.String()))
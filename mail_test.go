@@ -0,0 +1,73 @@
+package ebase
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// an Smtp in daemon mode whose server refuses connections
+func testSmtp(t *testing.T) *Smtp {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	l, _ := testLog(LogFormatText, 0)
+	old := Log
+	Log = l
+	t.Cleanup(func() { Log = old })
+
+	return &Smtp{SmtpHost: "127.0.0.1", SmtpPort: port, SmtpDaemon: true,
+		mailChan: make(chan *Mailer), done: make(chan struct{})}
+}
+
+func TestSmtpStartTwice(t *testing.T) {
+	s := testSmtp(t)
+	ctx := context.Background()
+
+	// started as a service and as an app component
+	s.Start(ctx)
+	s.Start(ctx)
+	s.MailSendServer()
+
+	// queued, the refused send only logged by the server
+	if err := s.MailSender("hi", "body", "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MailSender("hi", "body", "a@example.com"); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("send after close: %v", err)
+	}
+}
+
+func TestSmtpSendWithoutServer(t *testing.T) {
+	s := testSmtp(t)
+
+	// no MailSendServer: the mail is sent now and the refused connection
+	// reported instead of blocking
+	done := make(chan error, 1)
+	go func() { done <- s.MailSender("hi", "body", "a@example.com") }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("refused send succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("send blocked")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
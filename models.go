@@ -1,10 +1,12 @@
 package ebase
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	redis "github.com/alphazero/Go-Redis"
@@ -31,37 +33,39 @@ type (
 		RedisEnable  bool
 		RedisPrefix  string
 		Driver       string
+
+		closeOnce sync.Once
 	}
 
 	ModelOption struct {
-		Orm   OrmOption
-		Redis RedisOption
+		Orm   OrmOption   `cfg:"database"`
+		Redis RedisOption `cfg:"redis"`
 	}
 
 	OrmOption struct {
-		Driver    string
-		Proto     string
-		Host      string
-		User      string
-		Password  string
-		Name      string
-		Ssl       string
-		Path      string
-		Schema    string
-		Log       string
-		Port      int
-		CacheTime int
-		Cache     bool
-		Debug     bool
+		Driver    string `cfg:"driver" default:"postgres" values:"mysql,postgres,sqlite3"`
+		Proto     string `cfg:"proto" default:"tcp"`
+		Host      string `cfg:"host" default:"localhost"`
+		User      string `cfg:"user"`
+		Password  string `cfg:"password"`
+		Name      string `cfg:"name"`
+		Ssl       string `cfg:"ssl"`
+		Path      string `cfg:"path"`
+		Schema    string `cfg:"schema" default:"public"`
+		Log       string `cfg:"log" default:"var/database.log"`
+		Port      int    `cfg:"port" default:"5432"`
+		CacheTime int    `cfg:"cachetime" default:"300"`
+		Cache     bool   `cfg:"cache"`
+		Debug     bool   `cfg:"debug"`
 	}
 
 	RedisOption struct {
-		Host   string
-		Auth   string
-		Prefix string
-		Port   int
-		Db     int
-		Enable bool
+		Host   string `cfg:"host" default:"localhost"`
+		Auth   string `cfg:"auth"`
+		Prefix string `cfg:"keyprefix" default:"ebase"`
+		Port   int    `cfg:"port" default:"6379"`
+		Db     int    `cfg:"db"`
+		Enable bool   `cfg:"enable"`
 	}
 
 	PageOptions struct {
@@ -74,54 +78,17 @@ type (
 // 从默认配置加载数据库
 
 func NewDefaultModels() (dbh *Models, err error) {
-	dbDriver, _ := Config.String("database.driver", "postgres")
-	dbProto, _ := Config.String("database.proto", "tcp")
-	dbHost, _ := Config.String("database.host", "localhost")
-	dbUser, _ := Config.String("database.user", "")
-	dbPassword, _ := Config.String("database.password", "")
-	dbName, _ := Config.String("database.name", "")
-	dbSsl, _ := Config.String("database.ssl", "")
-	dbPath, _ := Config.String("database.path", "")
-	dbSchema, _ := Config.String("database.schema", "public")
-	dbPort, _ := Config.Int("database.port", 5432)
-	dbDebug, _ := Config.Bool("database.debug", false)
-	dbCache, _ := Config.Bool("database.cache", false)
-	dbCacheTime, _ := Config.Int("database.cachetime", 300)
-	dbLogFile, _ := Config.String("database.log", "var/database.log")
-
-	redisEnable, _ := Config.Bool("redis.enable", false)
-	redisHost, _ := Config.String("redis.host", "localhost")
-	redisAuth, _ := Config.String("redis.auth", "")
-	redisPort, _ := Config.Int("redis.port", 6379)
-	redisDb, _ := Config.Int("redis.db", 0)
-	redisKeyFix, _ := Config.String("redis.keyprefix", "ebase")
-
 	opt := new(ModelOption)
-	opt.Orm.Driver = dbDriver
-	opt.Orm.Host = dbHost
-	opt.Orm.Proto = dbProto
-	opt.Orm.User = dbUser
-	opt.Orm.Password = dbPassword
-	opt.Orm.Name = dbName
-	opt.Orm.Ssl = dbSsl
-	opt.Orm.Path = dbPath
-	opt.Orm.Schema = dbSchema
-	opt.Orm.Port = dbPort
-	opt.Orm.Debug = dbDebug
-	opt.Orm.Cache = dbCache
-	opt.Orm.CacheTime = dbCacheTime
-	opt.Orm.Log = dbLogFile
-
-	opt.Redis.Host = redisHost
-	opt.Redis.Enable = redisEnable
-	opt.Redis.Auth = redisAuth
-	opt.Redis.Port = redisPort
-	opt.Redis.Db = redisDb
-	opt.Redis.Prefix = redisKeyFix
+	if err = BindConfig("", opt); err != nil {
+		return
+	}
 
 	dbh, err = NewModels(opt)
 	if err == nil {
 		Dbh = dbh
+		RegisterShutdown("database", ShutdownLast-10, func(ctx context.Context) error {
+			return dbh.Close()
+		})
 	}
 
 	return
@@ -178,15 +145,26 @@ func NewModels(opt *ModelOption) (*Models, error) {
 }
 
 func (dbh *Models) Close() error {
-
-	dbh.Orm.Close()
-	if dbh.RedisEnable {
-		dbh.Redis.Quit()
-	}
+	dbh.closeOnce.Do(func() {
+		dbh.Orm.Close()
+		if dbh.RedisEnable {
+			dbh.Redis.Quit()
+		}
+	})
 
 	return nil
 }
 
+// Start checks the database connection, so the Models is an App Component
+func (dbh *Models) Start(ctx context.Context) error {
+	return dbh.Orm.PingContext(ctx)
+}
+
+// Stop is Close
+func (dbh *Models) Stop(ctx context.Context) error {
+	return dbh.Close()
+}
+
 func NewXorm(opt *OrmOption) (orm *xorm.Engine, err error) {
 	Log.Trace("db initializing...")
 	var dsn string
@@ -0,0 +1,189 @@
+package ebase
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// PidFile is a pid file holding an exclusive flock for the life of the
+// process, a second instance fails to lock it.
+type PidFile struct {
+	Path string
+	f    *os.File
+}
+
+// LockPid locks the pid file and writes the current pid into it. If
+// another process holds the lock it returns a *PidFileError with
+// ErrPidLocked and the running pid. An unlocked file is stale whatever pid
+// it names and is replaced atomically. The path is made absolute so the
+// file is still found after the daemon changes dir.
+func LockPid(path string) (*PidFile, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	var f *os.File
+
+	for i := 0; ; i++ {
+		var err error
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, &PidFileError{File: path, Err: err}
+		}
+
+		if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			pid, _ := readPid(f)
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, &PidFileError{File: path, Pid: pid, Err: ErrPidLocked}
+			}
+			return nil, &PidFileError{File: path, Err: err}
+		}
+
+		// the file was replaced while we waited, lock the new one
+		if sameFile(f, path) {
+			break
+		}
+		f.Close()
+		if i > 3 {
+			return nil, &PidFileError{File: path, Err: ErrPidLocked}
+		}
+	}
+	defer f.Close()
+
+	// write a locked temp file and rename it over the old one, so a
+	// reader never sees a partial pid
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return nil, &PidFileError{File: path, Err: err}
+	}
+
+	err = syscall.Flock(int(tmp.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == nil {
+		_, err = tmp.WriteString(GetIntStr(os.Getpid()) + "\n")
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, &PidFileError{File: path, Err: err}
+	}
+
+	return &PidFile{Path: path, f: tmp}, nil
+}
+
+// Remove deletes the pid file if it's still ours and releases the lock.
+// After dropping privileges the dir may not be writable anymore, the file
+// is then emptied instead, an unlocked file is stale to the next LockPid.
+func (p *PidFile) Remove() error {
+	if p == nil || p.f == nil {
+		return nil
+	}
+
+	var err error
+	if sameFile(p.f, p.Path) {
+		if err = os.Remove(p.Path); errors.Is(err, fs.ErrPermission) {
+			err = p.f.Truncate(0)
+		}
+	}
+	p.f.Close()
+	p.f = nil
+
+	return err
+}
+
+// release the lock but keep the file, for a hand over to a new process
+func (p *PidFile) unlock() {
+	if p != nil && p.f != nil {
+		syscall.Flock(int(p.f.Fd()), syscall.LOCK_UN)
+	}
+}
+
+// take the lock back after a failed hand over
+func (p *PidFile) relock() {
+	if p != nil && p.f != nil && sameFile(p.f, p.Path) {
+		syscall.Flock(int(p.f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	}
+}
+
+// PidStatus reads the pid file and reports whether the process it names
+// is running. A missing file is reported as stopped with no error.
+func PidStatus(path string) (pid int, running bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	defer f.Close()
+
+	pid, err = readPid(f)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// the lock is held as long as the owner lives
+	if syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB) == syscall.EWOULDBLOCK {
+		return pid, true, nil
+	}
+
+	return pid, pid > 0 && processAlive(pid), nil
+}
+
+func readPid(f *os.File) (int, error) {
+	buf := make([]byte, 32)
+	n, err := f.ReadAt(buf, 0)
+	if n == 0 {
+		if err == nil || err == io.EOF {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	s := strings.TrimSpace(string(buf[:n]))
+	if s == "" {
+		return 0, nil
+	}
+
+	pid, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad pid %q in %s", s, f.Name())
+	}
+
+	return pid, nil
+}
+
+func sameFile(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(fi, pi)
+}
+
+// signal 0 checks for existence, EPERM means it exists as another user
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,77 @@
+package ebase
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockPid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.pid")
+
+	// an unlocked file is stale even when its pid was reused by a live
+	// process, here the parent of the test
+	os.WriteFile(file, []byte(GetIntStr(os.Getppid())+"\n"), 0644)
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(filepath.Dir(file))
+
+	p, err := LockPid("app.pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Path != file {
+		t.Fatalf("path %s, want %s", p.Path, file)
+	}
+	// after a daemon changes dir
+	os.Chdir("/")
+
+	if content, _ := FileGetContent(file); content != GetIntStr(os.Getpid())+"\n" {
+		t.Fatalf("pid file content %q", content)
+	}
+
+	_, err = LockPid(file)
+	var pidErr *PidFileError
+	if !errors.As(err, &pidErr) || !errors.Is(err, ErrPidLocked) || pidErr.Pid != os.Getpid() {
+		t.Fatalf("second lock: got %v, want ErrPidLocked", err)
+	}
+
+	if pid, running, err := PidStatus(file); err != nil || !running || pid != os.Getpid() {
+		t.Fatalf("status pid %d running %v err %v", pid, running, err)
+	}
+
+	if err = p.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if IsExist(file) {
+		t.Fatal("pid file not removed")
+	}
+
+	if _, running, err := PidStatus(file); err != nil || running {
+		t.Fatalf("status after remove running %v err %v", running, err)
+	}
+}
+
+func TestRemovePidReadOnlyDir(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("root writes any dir")
+	}
+	dir := t.TempDir()
+	p, err := LockPid(filepath.Join(dir, "app.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// like the pid dir after dropping privileges
+	os.Chmod(dir, 0555)
+	defer os.Chmod(dir, 0755)
+
+	if err = p.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if pid, running, err := PidStatus(p.Path); err != nil || running || pid != 0 {
+		t.Fatalf("status pid %d running %v err %v", pid, running, err)
+	}
+}
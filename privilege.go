@@ -0,0 +1,169 @@
+package ebase
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// chroot dir set by NewApp, used in lookup errors
+var chrootDir string
+
+// user and groups to run as
+type Credential struct {
+	User   string
+	Uid    int
+	Gid    int
+	Groups []int // supplementary groups
+}
+
+// LookupCredential resolves user, group and supplementary group names or
+// numeric ids. An empty group uses the primary group of the user, a
+// numeric uid without a passwd entry needs an explicit group. After a
+// chroot the lookup reads the passwd and group files inside the chroot.
+func LookupCredential(name, group string, groups []string) (*Credential, error) {
+	cred := &Credential{User: name}
+
+	u, err := lookupUser(name)
+	if err != nil {
+		return nil, err
+	}
+	if u.Gid == "" && group == "" {
+		return nil, fmt.Errorf("uid %s has no passwd entry, set its group", name)
+	}
+	cred.Uid, _ = strconv.Atoi(u.Uid)
+	cred.Gid, _ = strconv.Atoi(u.Gid)
+
+	if group != "" {
+		if cred.Gid, err = lookupGroup(group); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, g := range groups {
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+		gid, err := lookupGroup(g)
+		if err != nil {
+			return nil, err
+		}
+		cred.Groups = append(cred.Groups, gid)
+	}
+
+	return cred, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u, nil
+	}
+	if _, e := strconv.Atoi(name); e == nil {
+		if u, e = user.LookupId(name); e == nil {
+			return u, nil
+		}
+		// a bare uid needs no passwd entry, its group is unknown
+		return &user.User{Uid: name, Username: name}, nil
+	}
+
+	if chrootDir != "" {
+		return nil, fmt.Errorf("user %q not found in chroot %s (check %s/etc/passwd): %s",
+			name, chrootDir, chrootDir, err)
+	}
+	return nil, fmt.Errorf("user %q not found: %s", name, err)
+}
+
+func lookupGroup(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		if chrootDir != "" {
+			return 0, fmt.Errorf("group %q not found in chroot %s (check %s/etc/group): %s",
+				name, chrootDir, chrootDir, err)
+		}
+		return 0, fmt.Errorf("group %q not found: %s", name, err)
+	}
+
+	return strconv.Atoi(g.Gid)
+}
+
+// Apply switches the process to the credential and verifies that root
+// can't be regained. Groups are set first while still privileged.
+func (c *Credential) Apply() error {
+	groups := c.Groups
+	if groups == nil {
+		groups = []int{c.Gid}
+	}
+
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups %v: %s", groups, err)
+	}
+	if err := syscall.Setgid(c.Gid); err != nil {
+		return fmt.Errorf("setgid %d: %s", c.Gid, err)
+	}
+	if err := syscall.Setuid(c.Uid); err != nil {
+		return fmt.Errorf("setuid %d: %s", c.Uid, err)
+	}
+
+	return c.verify()
+}
+
+func (c *Credential) verify() error {
+	if os.Getuid() != c.Uid || os.Geteuid() != c.Uid {
+		return fmt.Errorf("uid is %d/%d after drop, want %d", os.Getuid(), os.Geteuid(), c.Uid)
+	}
+	if os.Getgid() != c.Gid || os.Getegid() != c.Gid {
+		return fmt.Errorf("gid is %d/%d after drop, want %d", os.Getgid(), os.Getegid(), c.Gid)
+	}
+
+	if c.Uid != 0 {
+		if err := syscall.Setuid(0); err == nil {
+			return fmt.Errorf("privileges regained after drop to uid %d", c.Uid)
+		}
+		if err := syscall.Setgid(0); err == nil && c.Gid != 0 {
+			return fmt.Errorf("group privileges regained after drop to gid %d", c.Gid)
+		}
+	}
+
+	return nil
+}
+
+// DropPrivileges switches to sys.user, sys.group and sys.groups (comma
+// separated). Call it after chroot and after binding privileged ports.
+// It does nothing when sys.user is not set.
+func DropPrivileges() error {
+	name, _ := Config.String("sys.user", "")
+	if name == "" {
+		return nil
+	}
+	group, _ := Config.String("sys.group", "")
+	groups, _ := Config.String("sys.groups", "")
+
+	var list []string
+	if groups != "" {
+		list = strings.Split(groups, ",")
+	}
+
+	cred, err := LookupCredential(name, group, list)
+	if err != nil {
+		return err
+	}
+
+	if err = cred.Apply(); err != nil {
+		return err
+	}
+
+	if Log != nil {
+		Log.Infof("running as user %s uid %d gid %d", name, cred.Uid, cred.Gid)
+	}
+
+	return nil
+}
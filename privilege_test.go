@@ -0,0 +1,48 @@
+package ebase
+
+import (
+	"os/user"
+	"reflect"
+	"testing"
+)
+
+func TestLookupCredential(t *testing.T) {
+	if _, err := user.Lookup("root"); err != nil {
+		t.Skip("no root passwd entry:", err)
+	}
+	if _, err := user.LookupId("54321"); err == nil {
+		t.Skip("uid 54321 exists")
+	}
+
+	tests := []struct {
+		name, group string
+		groups      []string
+		want        *Credential // nil for an error
+	}{
+		{"root", "", nil, &Credential{User: "root", Uid: 0, Gid: 0}},
+		{"0", "", nil, &Credential{User: "0", Uid: 0, Gid: 0}},
+		{"root", "12", []string{"0", " 7 ", ""}, &Credential{User: "root", Uid: 0, Gid: 12, Groups: []int{0, 7}}},
+		{"54321", "54321", nil, &Credential{User: "54321", Uid: 54321, Gid: 54321}},
+		{"54321", "", nil, nil},        // uid without passwd entry needs a group
+		{"no-such-user", "", nil, nil}, // unknown user
+		{"root", "no-such-group", nil, nil},
+		{"root", "", []string{"no-such-group"}, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := LookupCredential(tt.name, tt.group, tt.groups)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s/%s: got %+v, want an error", tt.name, tt.group, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s/%s: %s", tt.name, tt.group, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s/%s: got %+v, want %+v", tt.name, tt.group, got, tt.want)
+		}
+	}
+}
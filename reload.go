@@ -0,0 +1,270 @@
+package ebase
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// key/value pairs of one config section
+type ConfigSection map[string]string
+
+// called after a reload with the old and new values of the subscribed
+// section, only when the section has changed
+type ConfigSubscriber func(old, new ConfigSection) error
+
+// checks a parsed config before it replaces the global Config
+type ConfigValidator func(cfg *Configuration) error
+
+type ConfigReloader struct {
+	File string // config file, defaults to the one loaded by EbaseInit
+
+	reload     sync.Mutex // serializes reloads
+	lock       sync.Mutex
+	subs       map[string][]ConfigSubscriber
+	validators []ConfigValidator
+	sections   map[string]ConfigSection
+	files      map[string]fileState // config files read, with includes and overlay
+}
+
+// what Watch compares of a config file
+type fileState struct {
+	mtime time.Time
+	size  int64
+}
+
+// default config reloader, triggered by SIGHUP and sys.config_watch
+var Reloader = NewConfigReloader("")
+
+func NewConfigReloader(file string) *ConfigReloader {
+	return &ConfigReloader{File: file, subs: make(map[string][]ConfigSubscriber)}
+}
+
+// subscribe to changes of a config section
+func (r *ConfigReloader) Subscribe(section string, fn ConfigSubscriber) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.subs[section] = append(r.subs[section], fn)
+}
+
+// add a validator run before a new config is accepted
+func (r *ConfigReloader) AddValidator(fn ConfigValidator) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.validators = append(r.validators, fn)
+}
+
+func (r *ConfigReloader) file() string {
+	if r.File != "" {
+		return r.File
+	}
+	return configPath
+}
+
+// Reload re-parses the config file and validates it. On success it
+// replaces the global Config and calls the subscribers of every changed
+// section. A config that fails to parse or validate is discarded and the
+// running config is kept.
+func (r *ConfigReloader) Reload() error {
+	r.reload.Lock()
+	defer r.reload.Unlock()
+
+	r.lock.Lock()
+	file := r.file()
+	validators := append([]ConfigValidator(nil), r.validators...)
+	r.lock.Unlock()
+
+	if file == "" {
+		return fmt.Errorf("config file not setup")
+	}
+
+	cfg, _, err := ReadConfig(file)
+	if err != nil {
+		return err
+	}
+	sections := cfg.Sections()
+
+	for _, fn := range validators {
+		if err = fn(cfg); err != nil {
+			return fmt.Errorf("config validate error: %s", err)
+		}
+	}
+
+	r.lock.Lock()
+	old := r.sections
+	r.sections = sections
+	r.files = statFiles(cfg.Files())
+	subs := make(map[string][]ConfigSubscriber, len(r.subs))
+	for name, list := range r.subs {
+		subs[name] = append([]ConfigSubscriber(nil), list...)
+	}
+	r.lock.Unlock()
+
+	setConfig(cfg)
+
+	// subscribers may subscribe or snapshot, they run without the lock
+	var errs []string
+	for name, list := range subs {
+		if sectionEqual(old[name], sections[name]) {
+			continue
+		}
+		for _, fn := range list {
+			if err := fn(old[name], sections[name]); err != nil {
+				errs = append(errs, fmt.Sprintf("[%s] %s", name, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config reload: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// publish cfg as the global Config. The running Config takes the values
+// of cfg under its lock, so readers holding the pointer see either the
+// old or the new config.
+func setConfig(cfg *Configuration) {
+	if Config == nil {
+		Config = cfg
+		Register(Services, "config", cfg)
+		return
+	}
+	Config.Replace(cfg)
+}
+
+// state of the files, zero for a missing file
+func statFiles(files []string) map[string]fileState {
+	state := make(map[string]fileState, len(files))
+	for _, file := range files {
+		var st fileState
+		if fi, err := os.Stat(file); err == nil {
+			st = fileState{mtime: fi.ModTime(), size: fi.Size()}
+		}
+		state[file] = st
+	}
+	return state
+}
+
+// whether a config file changed or was removed since the last check. The new state is kept so a broken file is reloaded only once.
+func (r *ConfigReloader) changed() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.files) == 0 {
+		return false
+	}
+	files := make([]string, 0, len(r.files))
+	for file := range r.files {
+		files = append(files, file)
+	}
+	now := statFiles(files)
+
+	changed := false
+	for file, st := range now {
+		if o := r.files[file]; !o.mtime.Equal(st.mtime) || o.size != st.size {
+			changed = true
+		}
+	}
+	if changed {
+		r.files = now
+	}
+	return changed
+}
+
+// Snapshot records the current file content as the base for the next
+// reload, EbaseInit calls it after loading the config.
+func (r *ConfigReloader) Snapshot() error {
+	r.lock.Lock()
+	file := r.file()
+	r.lock.Unlock()
+
+	cfg, _, err := ReadConfig(file)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sections = cfg.Sections()
+	r.files = statFiles(cfg.Files())
+
+	return nil
+}
+
+// Watch polls the config file, its includes and overlay every interval
+// and reloads when a modification time or size changes, until ctx is
+// done.
+func (r *ConfigReloader) Watch(ctx context.Context, interval time.Duration) {
+	r.lock.Lock()
+	snapshot := r.sections == nil
+	r.lock.Unlock()
+	if snapshot {
+		r.Snapshot()
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if r.changed() {
+				logReload(r.Reload())
+			}
+		}
+	}
+}
+
+func logReload(err error) {
+	if Log == nil {
+		return
+	}
+
+	if err != nil {
+		Log.Errorf("reload config error: %s", err)
+	} else {
+		Log.Info("config reloaded")
+	}
+}
+
+// signal handler that reloads the config
+func ReloadSignal(sig os.Signal) error {
+	sdNotify("RELOADING=1")
+	err := Reloader.Reload()
+	sdNotify("READY=1")
+	logReload(err)
+	return err
+}
+
+// reopen the default log with the new log section
+func reloadLog(old, new ConfigSection) error {
+	if Log == nil {
+		return nil
+	}
+	opt, err := defaultLogOptions()
+	if err != nil {
+		return err
+	}
+	return Log.Reopen(opt)
+}
+
+func sectionEqual(a, b ConfigSection) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package ebase
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.conf")
+	write := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("[log]\nlevel = 3\n[smtp]\nhost = a\n")
+
+	r := NewConfigReloader(file)
+	if err := r.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	var logOld, logNew ConfigSection
+	smtpCalled := false
+	r.Subscribe("log", func(old, new ConfigSection) error {
+		logOld, logNew = old, new
+		return nil
+	})
+	r.Subscribe("smtp", func(old, new ConfigSection) error {
+		smtpCalled = true
+		return nil
+	})
+	// subscribers run without the reloader lock
+	r.Subscribe("log", func(old, new ConfigSection) error {
+		r.Subscribe("db", func(old, new ConfigSection) error { return nil })
+		return r.Snapshot()
+	})
+
+	saved := Config
+	defer func() { Config = saved }()
+	Config = NewConfiguration()
+	running := Config
+
+	write("[log]\nlevel = 5\n[smtp]\nhost = a\n")
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if logOld["level"] != "3" || logNew["level"] != "5" {
+		t.Fatalf("log section old %v new %v", logOld, logNew)
+	}
+	if smtpCalled {
+		t.Fatal("unchanged smtp section notified")
+	}
+	if Config != running {
+		t.Fatal("Config pointer replaced")
+	}
+	if v, _ := Config.Int("log.level", 0); v != 5 {
+		t.Fatalf("Config log.level %d", v)
+	}
+
+	write("[log\nlevel = 1\n")
+	if err := r.Reload(); err == nil {
+		t.Fatal("bad config accepted")
+	}
+}
+
+func TestConfigWatchIncludes(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.conf")
+	inc := filepath.Join(dir, "local.conf")
+	os.WriteFile(file, []byte("include = local.conf\n[log]\nlevel = 3\n"), 0644)
+	os.WriteFile(inc, []byte("[smtp]\nhost = a\n"), 0644)
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+
+	cfg, loaded, err := ReadConfig("app.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != file {
+		t.Errorf("loaded %s, want the absolute %s", loaded, file)
+	}
+	if files := cfg.Files(); len(files) != 2 || files[1] != inc {
+		t.Errorf("files %v", files)
+	}
+
+	saved := Config
+	defer func() { Config = saved }()
+	Config = cfg
+
+	r := NewConfigReloader(loaded)
+	got := make(chan string, 1)
+	r.Subscribe("smtp", func(old, new ConfigSection) error {
+		got <- new["host"]
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+	go func() {
+		r.Watch(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	// after a daemon changes dir
+	os.Chdir("/")
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(inc, []byte("[smtp]\nhost = bb\n"), 0644)
+
+	select {
+	case host := <-got:
+		if host != "bb" {
+			t.Errorf("smtp host %s", host)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("include change not reloaded")
+	}
+}
@@ -0,0 +1,511 @@
+package ebase
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config value types checked by CheckConfig
+const (
+	TypeString   = "string"
+	TypeInt      = "int"
+	TypeUint     = "uint"
+	TypeFloat    = "float"
+	TypeBool     = "bool"
+	TypeDuration = "duration"
+	TypeList     = "list" // comma separated strings
+)
+
+// ConfigKey declares a config key. A * in Key matches any one part of a
+// key, e.g. log.sink.*.level.
+type ConfigKey struct {
+	Key      string   // section.key
+	Type     string   // one of the Type constants, "" is TypeString
+	Values   []string // allowed values, each list item for TypeList
+	Default  string
+	Required bool
+	Usage    string // one line description
+}
+
+// declared keys by Key
+var configSchema = make(map[string]*ConfigKey)
+
+// DeclareConfig adds keys to the config schema, a key declared again is
+// replaced. Once a section has a declared key, CheckConfig reports the
+// unknown keys in it.
+//
+//	ebase.DeclareConfig(ebase.ConfigKey{Key: "api.listen", Default: ":8080", Usage: "Listen address"})
+func DeclareConfig(keys ...ConfigKey) {
+	for i := range keys {
+		k := keys[i]
+		if k.Type == "" {
+			k.Type = TypeString
+		}
+		configSchema[k.Key] = &k
+	}
+}
+
+// DeclareConfigStruct declares the keys Bind fills in v, a struct or a
+// pointer to one. Besides the cfg, default and required tags a field may
+// have the allowed values and a description:
+//
+//	Driver string `cfg:"driver" default:"postgres" values:"mysql,postgres,sqlite3" usage:"Database driver"`
+func DeclareConfigStruct(section string, v interface{}) {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("declare config: want struct, got %T", v))
+	}
+	declareStruct(section, t)
+}
+
+func declareStruct(prefix string, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Tag.Get("cfg")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			declareStruct(key, field.Type)
+			continue
+		}
+
+		k := ConfigKey{Key: key, Type: fieldType(field.Type),
+			Default:  field.Tag.Get("default"),
+			Required: field.Tag.Get("required") == "true",
+			Usage:    field.Tag.Get("usage")}
+		if values := field.Tag.Get("values"); values != "" {
+			k.Values = strings.Split(values, ",")
+		}
+		DeclareConfig(k)
+	}
+}
+
+func fieldType(t reflect.Type) string {
+	if t == durationType {
+		return TypeDuration
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return TypeBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return TypeInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return TypeUint
+	case reflect.Float32, reflect.Float64:
+		return TypeFloat
+	case reflect.Slice:
+		return TypeList
+	}
+	return TypeString
+}
+
+// the declaration matching key, nil for an unknown key. Of the patterns
+// matching it the one with the leftmost literal part wins, api.peer.* over
+// api.*.weight.
+func lookupSchema(key string) *ConfigKey {
+	if k, ok := configSchema[key]; ok {
+		return k
+	}
+
+	parts := strings.Split(key, ".")
+	var best *ConfigKey
+	var bestPattern []string
+	for pattern, k := range configSchema {
+		p := strings.Split(pattern, ".")
+		if matchKey(p, parts) && (best == nil || moreSpecific(p, bestPattern)) {
+			best, bestPattern = k, p
+		}
+	}
+	return best
+}
+
+// whether pattern a has a literal part where b has its first differing *,
+// both matching the same key
+func moreSpecific(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return b[i] == "*"
+		}
+	}
+	return false
+}
+
+func matchKey(pattern, parts []string) bool {
+	if len(pattern) != len(parts) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && p != parts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// whether any key of section is declared
+func declaredSection(section string) bool {
+	for key := range configSchema {
+		if keySection(key) == section {
+			return true
+		}
+	}
+	return false
+}
+
+func keySection(key string) string {
+	if i := strings.IndexByte(key, '.'); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// Check validates c against the declared schema: unknown keys in declared
+// sections, missing required keys and values of the wrong type or not
+// allowed. origins gives the file:line of file keys, it may be nil. The
+// error is a *ConfigCheckError listing every problem.
+func (c *Configuration) Check(origins map[string]string) error {
+	checkErr := new(ConfigCheckError)
+	problem := func(key, layer, format string, v ...interface{}) {
+		where := layer
+		switch layer {
+		case LayerFile:
+			if where = origins[key]; where == "" {
+				where = "config"
+			}
+		case LayerEnv:
+			where = "$" + EnvPrefix + "_" + envName(key)
+		case LayerFlag:
+			where = "--set " + key
+		}
+		checkErr.Problems = append(checkErr.Problems, where+": "+fmt.Sprintf(format, v...))
+	}
+
+	for _, key := range c.Keys() {
+		value, layer, _ := c.Lookup(key)
+
+		k := lookupSchema(key)
+		if k == nil {
+			// env keys are guessed from the variable names
+			if layer != LayerEnv && declaredSection(keySection(key)) {
+				problem(key, layer, "unknown key %s", key)
+			}
+			continue
+		}
+
+		// secrets are resolved at runtime
+		if IsSecretKey(key) && IsSecretRef(value) {
+			continue
+		}
+		if err := k.check(value); err != nil {
+			problem(key, layer, "bad %s %q: %s", key, Redact(key, value), err)
+		}
+	}
+
+	keys := make([]string, 0, len(configSchema))
+	for key, k := range configSchema {
+		if k.Required && !strings.Contains(key, "*") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, _, ok := c.Lookup(key); !ok {
+			problem(key, "config", "missing %s", key)
+		}
+	}
+
+	if len(checkErr.Problems) > 0 {
+		return checkErr
+	}
+	return nil
+}
+
+// check value against the type and allowed values
+func (k *ConfigKey) check(value string) error {
+	if value == "" {
+		if k.Required {
+			return fmt.Errorf("empty")
+		}
+		return nil
+	}
+
+	if k.Type == TypeList {
+		for _, v := range strings.Split(value, ",") {
+			if err := k.allowed(strings.TrimSpace(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	switch k.Type {
+	case TypeInt:
+		if _, e := strconv.ParseInt(value, 0, 64); e != nil {
+			err = fmt.Errorf("want integer")
+		}
+	case TypeUint:
+		if _, e := strconv.ParseUint(value, 0, 64); e != nil {
+			err = fmt.Errorf("want unsigned integer")
+		}
+	case TypeFloat:
+		if _, e := strconv.ParseFloat(value, 64); e != nil {
+			err = fmt.Errorf("want number")
+		}
+	case TypeBool:
+		_, err = parseBool(value)
+	case TypeDuration:
+		_, err = parseDuration(value)
+	}
+	if err != nil {
+		return err
+	}
+
+	return k.allowed(value)
+}
+
+func (k *ConfigKey) allowed(value string) error {
+	if len(k.Values) == 0 {
+		return nil
+	}
+	for _, v := range k.Values {
+		if v == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("want one of %s", strings.Join(k.Values, ", "))
+}
+
+// WriteConfigSchema writes the declared keys as a commented ini config
+func WriteConfigSchema(w io.Writer) error {
+	keys := make([]string, 0, len(configSchema))
+	for key := range configSchema {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		si, sj := keySection(keys[i]), keySection(keys[j])
+		if si != sj {
+			return si < sj
+		}
+		return keys[i] < keys[j]
+	})
+
+	section := ""
+	for _, key := range keys {
+		k := configSchema[key]
+		if s := keySection(key); s != section {
+			section = s
+			if _, err := fmt.Fprintf(w, "\n[%s]\n", section); err != nil {
+				return err
+			}
+		}
+
+		var notes []string
+		if k.Usage != "" {
+			notes = append(notes, k.Usage)
+		}
+		note := k.Type
+		if len(k.Values) > 0 {
+			note += ": " + strings.Join(k.Values, ", ")
+		}
+		if k.Required {
+			note += ", required"
+		}
+		notes = append(notes, note)
+
+		name := key
+		if section != "" {
+			name = key[len(section)+1:]
+		}
+		if _, err := fmt.Fprintf(w, "# %s\n%s = %s\n", strings.Join(notes, ", "), name, k.Default); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// file:line of each key in file, the files it includes and the overlay
+// for env, in the precedence ReadConfigFiles merges them
+func configOrigins(file, env string) (map[string]string, error) {
+	origins := make(map[string]string)
+	if _, err := readConfigFiles(file, env, origins, nil); err != nil {
+		return nil, err
+	}
+	return origins, nil
+}
+
+// record the file:line of the keys of file in origins. Lines are found for
+// ini, YAML and JSON files and for TOML tables and plain keys.
+func recordOrigins(file string, sections map[string]ConfigSection, origins map[string]string) {
+	var lines map[string]int
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		lines = yamlKeyLines(file)
+	default:
+		lines = iniKeyLines(file)
+	}
+
+	for key := range flattenSections(sections) {
+		if n := lines[key]; n > 0 {
+			origins[key] = file + ":" + strconv.Itoa(n)
+		} else {
+			origins[key] = file
+		}
+	}
+}
+
+// lines of the keys of an ini file, tables of a TOML file are read as
+// sections
+func iniKeyLines(file string) map[string]int {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+
+	lines := make(map[string]int)
+	section := ""
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			section = strings.Trim(line, "[] ")
+			continue
+		}
+		if i := strings.IndexAny(line, "=:"); i > 0 {
+			key := strings.Trim(strings.TrimSpace(line[:i]), `"'`)
+			if section != "" {
+				key = section + "." + key
+			}
+			lines[key] = n + 1
+		}
+	}
+
+	return lines
+}
+
+// lines of the keys of a YAML or JSON file
+func yamlKeyLines(file string) map[string]int {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+
+	lines := make(map[string]int)
+	walkYAML("", doc.Content[0], lines)
+	return lines
+}
+
+func walkYAML(prefix string, node *yaml.Node, lines map[string]int) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			lines[key] = node.Content[i].Line
+			walkYAML(key, node.Content[i+1], lines)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			key := prefix + "." + strconv.Itoa(i)
+			lines[key] = item.Line
+			walkYAML(key, item, lines)
+		}
+	}
+}
+
+var checkFlags = flag.NewFlagSet("check-config", flag.ContinueOnError)
+
+func init() {
+	schema := checkFlags.Bool("schema", false, "Print the declared keys as a sample config instead")
+
+	RegisterCommand(&Command{Name: "check-config", Usage: "Check the config against the declared keys and exit",
+		Flags: checkFlags,
+		Run: func(args []string) error {
+			if *schema {
+				return WriteConfigSchema(os.Stdout)
+			}
+			return inWorkDir(CheckConfigFile)
+		}})
+
+	DeclareConfigStruct("log", LogOptions{})
+	DeclareConfigStruct(logSinkPrefix+"*", LogOptions{})
+	DeclareConfigStruct("", ModelOption{})
+	DeclareConfigStruct("smtp", Smtp{})
+	DeclareConfig(
+		ConfigKey{Key: "sys.pid", Usage: "Pid file", Default: "/var/run/<app>.pid"},
+		ConfigKey{Key: "sys.umask", Usage: "Umask of the daemon, octal"},
+		ConfigKey{Key: "sys.stdout", Usage: "Daemon stdout file"},
+		ConfigKey{Key: "sys.stderr", Usage: "Daemon stderr file"},
+		ConfigKey{Key: "sys.daemon_timeout", Type: TypeInt, Usage: "Seconds to wait for the daemon to get ready"},
+		ConfigKey{Key: "sys.shutdown_timeout", Type: TypeInt, Usage: "Seconds to wait for the shutdown hooks"},
+		ConfigKey{Key: "sys.stop_timeout", Type: TypeInt, Default: "30", Usage: "Seconds stop waits before SIGKILL"},
+		ConfigKey{Key: "sys.restart_timeout", Type: TypeInt, Usage: "Seconds the old process waits on graceful restart"},
+		ConfigKey{Key: "sys.supervise", Type: TypeBool, Usage: "Restart crashed workers"},
+		ConfigKey{Key: "sys.restart_max", Type: TypeInt, Usage: "Worker restarts allowed in sys.restart_window"},
+		ConfigKey{Key: "sys.restart_window", Type: TypeInt, Usage: "Seconds counted for sys.restart_max"},
+		ConfigKey{Key: "sys.restart_backoff", Type: TypeInt, Usage: "Max seconds between worker restarts"},
+		ConfigKey{Key: "sys.signal", Type: TypeBool, Default: "true", Usage: "Handle SIGHUP and SIGUSR2"},
+		ConfigKey{Key: "sys.config_watch", Type: TypeInt, Usage: "Seconds between config file change checks, 0 disables"},
+		ConfigKey{Key: "sys.user", Usage: "User to run as"},
+		ConfigKey{Key: "sys.group", Usage: "Group to run as"},
+		ConfigKey{Key: "sys.groups", Type: TypeList, Usage: "Supplementary groups"},
+		ConfigKey{Key: "sys.secret_key", Usage: "Master key file for enc: values", Default: "etc/<app>.key"},
+	)
+}
+
+// CheckConfigFile reads the -c config with its includes and overlay and
+// checks it against the declared keys
+func CheckConfigFile() error {
+	cfg, file, err := ReadConfig(*cfgfile)
+	if err != nil {
+		return err
+	}
+
+	origins, err := configOrigins(file, ConfigEnv())
+	if err != nil {
+		return err
+	}
+	if err = cfg.Check(origins); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: ok\n", file)
+	return nil
+}
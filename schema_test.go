@@ -0,0 +1,76 @@
+package ebase
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigCheck(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app.conf": "include = app.d/*.yaml\n[log]\ntype = consloe\nlevel = 3\nlevle = 4\n" +
+			"[database]\ndriver = oracle\nport = 54x2\npassword = env:DB_PASS\n[custom]\nanything = 1\n",
+		"app.d/redis.yaml": "redis:\n  port: 6379\n  enabel: true\n",
+	})
+	file := filepath.Join(dir, "app.conf")
+
+	sections, err := ReadConfigFiles(file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins, err := configOrigins(file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origins["database.port"] != file+":8" {
+		t.Errorf("origin %q", origins["database.port"])
+	}
+
+	cfg := NewConfiguration(NewMapSource(LayerFile, flattenSections(sections)),
+		NewMapSource(LayerFlag, map[string]string{"sys.signal": "maybe"}))
+	err = cfg.Check(origins)
+
+	var checkErr *ConfigCheckError
+	if !errors.As(err, &checkErr) {
+		t.Fatalf("err = %v", err)
+	}
+	want := []string{
+		file + ":7: bad database.driver \"oracle\": want one of mysql, postgres, sqlite3",
+		file + ":8: bad database.port \"54x2\": want integer",
+		file + ":5: unknown key log.levle",
+		file + ":3: bad log.type \"consloe\": want one of console, file, syslog, system",
+		filepath.Join(dir, "app.d/redis.yaml") + ":3: unknown key redis.enabel",
+		"--set sys.signal: bad sys.signal \"maybe\": want true or false",
+	}
+	if strings.Join(checkErr.Problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems\n%s\nwant\n%s", err, strings.Join(want, "\n"))
+	}
+}
+
+func TestDeclareConfigRequired(t *testing.T) {
+	defer func(schema map[string]*ConfigKey) { configSchema = schema }(configSchema)
+	configSchema = make(map[string]*ConfigKey)
+
+	DeclareConfigStruct("api", &struct {
+		Listen string   `cfg:"listen" required:"true"`
+		Modes  []string `cfg:"modes" values:"a,b"`
+	}{})
+	DeclareConfig(ConfigKey{Key: "api.peer.*.weight", Type: TypeFloat})
+	// less specific patterns never win, whatever the map order
+	DeclareConfig(ConfigKey{Key: "api.*.*.weight", Type: TypeBool}, ConfigKey{Key: "*.peer.*.weight", Type: TypeBool})
+
+	cfg := NewConfiguration(NewMapSource(LayerFile, map[string]string{
+		"api.modes":         "a, c",
+		"api.peer.1.weight": "0.5",
+		"api.peer.2.weight": "heavy",
+	}))
+	err := cfg.Check(nil)
+	want := "config: bad api.modes \"a, c\": want one of a, b\n" +
+		"config: bad api.peer.2.weight \"heavy\": want number\n" +
+		"config: missing api.listen"
+	if err == nil || err.Error() != want {
+		t.Errorf("err\n%v\nwant\n%s", err, want)
+	}
+}
@@ -0,0 +1,283 @@
+package ebase
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// values of secret keys, see IsSecretKey, starting with these prefixes are
+// references to a secret:
+//
+//	password = file:/run/secrets/db   # content of the file
+//	password = env:DB_PASS            # value of the environment variable
+//	password = enc:<base64>           # decrypted with the master key
+const (
+	secretFile = "file:"
+	secretEnv  = "env:"
+	secretEnc  = "enc:"
+)
+
+// shown instead of a secret value
+const redacted = "******"
+
+// IsSecretRef reports whether value is a file:, env: or enc: reference
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretFile) ||
+		strings.HasPrefix(value, secretEnv) ||
+		strings.HasPrefix(value, secretEnc)
+}
+
+// IsSecretKey reports whether the config key names a secret, like
+// database.password or redis.auth
+func IsSecretKey(key string) bool {
+	name := strings.ToLower(key[strings.LastIndexByte(key, '.')+1:])
+	switch {
+	case name == "auth", name == "pass", name == "key",
+		strings.Contains(name, "password"), strings.Contains(name, "passwd"),
+		strings.Contains(name, "secret"), strings.Contains(name, "token"),
+		strings.HasSuffix(name, "_key"):
+		return true
+	}
+	return false
+}
+
+// Redact returns value fit for logs and dumps: plain values of secret keys
+// are masked, references are shown as they are
+func Redact(key, value string) string {
+	if value == "" || IsSecretRef(value) || !IsSecretKey(key) {
+		return value
+	}
+	return redacted
+}
+
+// SecretKeyFile returns the master key file for enc: values, sys.secret_key
+// or etc/<app>.key, relative to the work dir the config was loaded in
+func (c *Configuration) SecretKeyFile() string {
+	if file, _, ok := c.Lookup("sys.secret_key"); ok && file != "" {
+		return c.path(file)
+	}
+	return c.path(filepath.Join("etc", AppName+".key"))
+}
+
+// file against the work dir at load, the process may have changed dir
+// since
+func (c *Configuration) path(file string) string {
+	c.lock.RLock()
+	dir := c.dir
+	c.lock.RUnlock()
+
+	if dir == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(dir, file)
+}
+
+// Resolve returns the secret value refers to, other values are returned
+// unchanged. A relative file: path is against the work dir the config was
+// loaded in.
+func (c *Configuration) Resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretFile):
+		file := c.path(value[len(secretFile):])
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("read secret: %s", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	case strings.HasPrefix(value, secretEnv):
+		name := value[len(secretEnv):]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret env %s not set", name)
+		}
+		return v, nil
+
+	case strings.HasPrefix(value, secretEnc):
+		key, err := c.secretKey()
+		if err != nil {
+			return "", err
+		}
+		return DecryptSecret(key, value)
+	}
+
+	return value, nil
+}
+
+// value resolved when key names a secret, other keys keep a value like
+// env:prod as it is
+func (c *Configuration) resolveKey(key, value string) (string, error) {
+	if !IsSecretKey(key) {
+		return value, nil
+	}
+	return c.Resolve(value)
+}
+
+// the master key, read once per key file until the config is replaced
+func (c *Configuration) secretKey() ([]byte, error) {
+	file := c.SecretKeyFile()
+
+	c.keyLock.Lock()
+	defer c.keyLock.Unlock()
+
+	if c.key != nil && c.keyFile == file {
+		return c.key, nil
+	}
+	key, err := ReadSecretKey(file)
+	if err != nil {
+		return nil, err
+	}
+	c.keyFile, c.key = file, key
+	return key, nil
+}
+
+// ReadSecretKey reads a master key file holding 32 bytes, raw or base64
+// encoded
+func ReadSecretKey(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read secret key: %w", err)
+	}
+
+	if len(data) == 32 {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("secret key %s: want 32 bytes, raw or base64", file)
+	}
+	return key, nil
+}
+
+// GenerateSecretKey writes a new random master key to file, which must not
+// exist
+func GenerateSecretKey(file string) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fmt.Fprintln(f, base64.StdEncoding.EncodeToString(key))
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// EncryptSecret encrypts plain with AES-256-GCM and returns an enc: value
+func EncryptSecret(key []byte, plain string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return secretEnc + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret decrypts an enc: value made by EncryptSecret
+func DecryptSecret(key []byte, value string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretEnc))
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", errors.New("bad encrypted secret")
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("decrypt secret: wrong key or corrupted value")
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var encryptFlags = flag.NewFlagSet("encrypt", flag.ContinueOnError)
+
+func init() {
+	keyFile := encryptFlags.String("k", "", "Master `key` file, created when missing [Default: sys.secret_key or etc/"+AppName+".key]")
+
+	RegisterCommand(&Command{Name: "encrypt", Usage: "Encrypt a config value (argument or stdin) to enc:...",
+		Flags: encryptFlags,
+		Run: func(args []string) error {
+			return encryptCommand(*keyFile, args)
+		}})
+}
+
+func encryptCommand(keyFile string, args []string) error {
+	var plain string
+	if len(args) > 0 {
+		plain = strings.Join(args, " ")
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		plain = strings.TrimRight(line, "\r\n")
+	}
+
+	if keyFile == "" {
+		err := inWorkDir(func() (err error) {
+			keyFile = filepath.Join("etc", AppName+".key")
+			if cfg, _, e := ReadConfig(*cfgfile); e == nil {
+				keyFile = cfg.SecretKeyFile()
+			}
+			keyFile, err = filepath.Abs(keyFile)
+			return
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := ReadSecretKey(keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if key, err = GenerateSecretKey(keyFile); err == nil {
+			fmt.Fprintf(os.Stderr, "created master key %s\n", keyFile)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	value, err := EncryptSecret(key, plain)
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package ebase
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "app.key")
+	key, err := GenerateSecretKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := EncryptSecret(key, "enc-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secretFile := filepath.Join(dir, "db")
+	os.WriteFile(secretFile, []byte("file-pass\n"), 0600)
+	t.Setenv("TEST_SMTP_PASS", "env-pass")
+
+	cfg := NewConfiguration(NewMapSource(LayerFile, map[string]string{
+		"sys.secret_key":    keyFile,
+		"database.password": "file:" + secretFile,
+		"smtp.password":     "env:TEST_SMTP_PASS",
+		"redis.auth":        enc,
+		"redis.host":        "localhost",
+	}))
+
+	for k, want := range map[string]string{
+		"database.password": "file-pass",
+		"smtp.password":     "env-pass",
+		"redis.auth":        "enc-pass",
+		"redis.host":        "localhost",
+	} {
+		if v, err := cfg.String(k, ""); err != nil || v != want {
+			t.Errorf("%s = %q, %v, want %q", k, v, err, want)
+		}
+	}
+
+	opt := new(ModelOption)
+	if err := cfg.Bind("", opt); err != nil {
+		t.Fatal(err)
+	}
+	if opt.Orm.Password != "file-pass" || opt.Redis.Auth != "enc-pass" {
+		t.Errorf("bind got %q %q", opt.Orm.Password, opt.Redis.Auth)
+	}
+
+	// only secret keys are resolved
+	t.Setenv("TEST_ENV", "prod")
+	plain := NewConfiguration(NewMapSource(LayerFile, map[string]string{"app.mode": "env:TEST_ENV"}))
+	if v, err := plain.String("app.mode", ""); err != nil || v != "env:TEST_ENV" {
+		t.Errorf("app.mode = %q, %v", v, err)
+	}
+
+	// the master key is read once, a replaced config reads it again
+	os.Remove(keyFile)
+	GenerateSecretKey(keyFile)
+	if v, err := cfg.String("redis.auth", ""); err != nil || v != "enc-pass" {
+		t.Errorf("cached key: %q, %v", v, err)
+	}
+	cfg.Replace(NewConfiguration(cfg.Layer(LayerFile)))
+	if _, err := cfg.String("redis.auth", ""); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct{ key, value, want string }{
+		{"database.password", "s3cret", redacted},
+		{"redis.auth", "s3cret", redacted},
+		{"smtp.password", "env:SMTP_PASS", "env:SMTP_PASS"},
+		{"database.host", "db1", "db1"},
+		{"redis.keyprefix", "ebase", "ebase"},
+		{"database.password", "", ""},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.key, tt.value); got != tt.want {
+			t.Errorf("Redact(%s, %s) = %q, want %q", tt.key, tt.value, got, tt.want)
+		}
+	}
+
+	var dst struct {
+		Password int `cfg:"password"`
+	}
+	cfg := NewConfiguration(NewMapSource(LayerFile, map[string]string{"db.password": "hunter2"}))
+	if err := cfg.Bind("db", &dst); err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("bind error %v leaks the secret", err)
+	}
+}
+
+func TestSecretPathsAfterChdir(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	os.Mkdir(filepath.Join(dir, "keys"), 0700)
+	key, err := GenerateSecretKey(filepath.Join(dir, "keys", "app.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, _ := EncryptSecret(key, "enc-pass")
+	writeFiles(t, dir, map[string]string{
+		"app.conf":  "[sys]\nsecret_key = keys/app.key\n[redis]\nauth = " + enc + "\n[database]\npassword = file:db.secret\n",
+		"db.secret": "file-pass\n",
+	})
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	defer func(dir string) { appWorkDir = dir }(appWorkDir)
+	appWorkDir = ""
+	os.Chdir(dir)
+
+	cfg, file, err := ReadConfig("app.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(when string) {
+		t.Helper()
+		if v, err := cfg.String("redis.auth", ""); err != nil || v != "enc-pass" {
+			t.Errorf("%s: redis.auth = %q, %v", when, v, err)
+		}
+		if v, err := cfg.String("database.password", ""); err != nil || v != "file-pass" {
+			t.Errorf("%s: database.password = %q, %v", when, v, err)
+		}
+	}
+
+	// a daemon changes to / before the first secret is read
+	os.Chdir("/")
+	check("after chdir")
+
+	// a reload in / reads the config against the app work dir
+	appWorkDir = dir
+	reloaded, _, err := ReadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Replace(reloaded)
+	check("after reload")
+}
@@ -0,0 +1,306 @@
+package ebase
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// a service with a Start method is started by Container.Start, or when it
+// is created after that
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// a service with a Stop method is stopped by Container.Stop
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+type service struct {
+	name    string
+	typ     reflect.Type // type given to Provide or Register
+	provide func(c *Container) (interface{}, error)
+	value   interface{}
+	ready   bool
+	started bool
+}
+
+type registry struct {
+	lock     sync.RWMutex
+	create   sync.Mutex // held while a service tree is created
+	services map[string]*service
+	names    []string   // registration order
+	created  []*service // creation order, stopped in reverse
+	ctx      context.Context
+}
+
+// Container holds the services of an app by name. Services are created on
+// first use and may resolve the services they depend on from the
+// container passed to their provider. It is safe for concurrent use.
+type Container struct {
+	*registry
+	chain []string // services being created, to report cycles
+}
+
+// default container, EbaseInit wires Config, Log, Dbh and Smtp into it
+var Services = NewContainer()
+
+func NewContainer() *Container {
+	return &Container{registry: &registry{services: make(map[string]*service)}}
+}
+
+// Provide registers a service created by fn on first use, an empty name
+// uses the type name. A service with the same name is replaced.
+//
+//	ebase.Provide(ebase.Services, "cache", func(c *ebase.Container) (*Cache, error) {
+//		cfg, err := ebase.Resolve[*ebase.Configuration](c, "config")
+//		...
+//	})
+func Provide[T any](c *Container, name string, fn func(c *Container) (T, error)) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	c.add(&service{name: serviceName(name, typ), typ: typ,
+		provide: func(c *Container) (interface{}, error) {
+			return fn(c)
+		}})
+}
+
+// Register adds a created service, an empty name uses the type name
+func Register[T any](c *Container, name string, v T) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	c.add(&service{name: serviceName(name, typ), typ: typ, value: v, ready: true})
+}
+
+// Resolve returns the service by name, creating it if needed. An empty
+// name finds the one service of type T.
+func Resolve[T any](c *Container, name string) (v T, err error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if name == "" {
+		if name, err = c.nameOf(typ); err != nil {
+			return v, err
+		}
+	}
+
+	value, err := c.Get(name)
+	if err != nil {
+		return v, err
+	}
+	if value == nil {
+		return v, nil
+	}
+
+	v, ok := value.(T)
+	if !ok {
+		return v, fmt.Errorf("service %s: %T is not %s", name, value, typ)
+	}
+	return v, nil
+}
+
+// MustResolve is Resolve panicking on error, for services the program
+// can't run without
+func MustResolve[T any](c *Container, name string) T {
+	v, err := Resolve[T](c, name)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func serviceName(name string, typ reflect.Type) string {
+	if name == "" {
+		return typ.String()
+	}
+	return name
+}
+
+func (c *Container) add(s *service) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.services[s.name]; !ok {
+		c.names = append(c.names, s.name)
+	}
+	c.services[s.name] = s
+}
+
+// the name of the only service assignable to typ
+func (c *Container) nameOf(typ reflect.Type) (string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var found []string
+	for _, name := range c.names {
+		if c.services[name].typ.AssignableTo(typ) {
+			found = append(found, name)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("service of type %s: %w", typ, ErrNoService)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("service of type %s: ambiguous, one of %s", typ, strings.Join(found, ", "))
+}
+
+// Has reports whether a service is registered under name
+func (c *Container) Has(name string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	_, ok := c.services[name]
+	return ok
+}
+
+// Names returns the registered services in registration order
+func (c *Container) Names() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return append([]string(nil), c.names...)
+}
+
+// Get returns the service by name, creating it if needed. A failed
+// provider is called again on the next Get.
+func (c *Container) Get(name string) (interface{}, error) {
+	c.lock.RLock()
+	s, ok := c.services[name]
+	if ok && s.ready {
+		v := s.value
+		c.lock.RUnlock()
+		return v, nil
+	}
+	c.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("service %s: %w", name, ErrNoService)
+	}
+
+	for _, n := range c.chain {
+		if n == name {
+			return nil, fmt.Errorf("service %s: %w: %s", name, ErrServiceCycle,
+				strings.Join(append(c.chain, name), " -> "))
+		}
+	}
+
+	// one service tree at a time, providers resolve their dependencies
+	// through the child container without taking the lock again
+	if len(c.chain) == 0 {
+		c.create.Lock()
+		defer c.create.Unlock()
+	}
+
+	c.lock.RLock()
+	s = c.services[name]
+	ready, v := s.ready, s.value
+	c.lock.RUnlock()
+	if ready {
+		return v, nil
+	}
+
+	child := &Container{registry: c.registry, chain: append(append([]string(nil), c.chain...), name)}
+	v, err := s.provide(child)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", name, err)
+	}
+
+	c.lock.Lock()
+	s.value, s.ready = v, true
+	c.created = append(c.created, s)
+	ctx := c.ctx
+	c.lock.Unlock()
+
+	if ctx != nil {
+		if err = c.start(ctx, s); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// Start creates every registered service in registration order and starts
+// the ones with a Start method. Services created later are started when
+// they are created.
+func (c *Container) Start(ctx context.Context) error {
+	c.lock.Lock()
+	c.ctx = ctx
+	names := append([]string(nil), c.names...)
+	c.lock.Unlock()
+
+	for _, name := range names {
+		if _, err := c.Get(name); err != nil {
+			return err
+		}
+
+		c.lock.RLock()
+		s := c.services[name]
+		c.lock.RUnlock()
+		if err := c.start(ctx, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Container) start(ctx context.Context, s *service) error {
+	c.lock.Lock()
+	starter, ok := s.value.(Starter)
+	if !ok || s.started {
+		c.lock.Unlock()
+		return nil
+	}
+	s.started = true
+	c.lock.Unlock()
+
+	if err := starter.Start(ctx); err != nil {
+		return fmt.Errorf("start service %s: %s", s.name, err)
+	}
+	return nil
+}
+
+// Stop stops the created services with a Stop method in reverse creation
+// order, so a service stops before the services it depends on. Every
+// service is stopped, the first error is returned.
+func (c *Container) Stop(ctx context.Context) error {
+	c.lock.Lock()
+	created := c.created
+	c.created = nil
+	c.ctx = nil
+	c.lock.Unlock()
+
+	var err error
+	for i := len(created) - 1; i >= 0; i-- {
+		s := created[i]
+		stopper, ok := s.value.(Stopper)
+		if !ok {
+			continue
+		}
+		if e := stopper.Stop(ctx); e != nil && err == nil {
+			err = fmt.Errorf("stop service %s: %s", s.name, e)
+		}
+	}
+
+	return err
+}
+
+// register the core services in Services, Dbh and Smtp are created on
+// first use
+func wireServices() {
+	Register(Services, "config", Config)
+	Register(Services, "log", Log)
+	Provide(Services, "dbh", func(c *Container) (*Models, error) {
+		if Dbh != nil {
+			return Dbh, nil
+		}
+		return NewDefaultModels()
+	})
+	Provide(Services, "smtp", func(c *Container) (*Smtp, error) {
+		return NewSmtp(), nil
+	})
+
+	RegisterShutdown("services", ShutdownDefault, Services.Stop)
+}
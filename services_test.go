@@ -0,0 +1,89 @@
+package ebase
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testService struct {
+	name string
+	log  *[]string
+}
+
+func (s *testService) Start(ctx context.Context) error {
+	*s.log = append(*s.log, "start "+s.name)
+	return nil
+}
+
+func (s *testService) Stop(ctx context.Context) error {
+	*s.log = append(*s.log, "stop "+s.name)
+	return nil
+}
+
+func TestContainer(t *testing.T) {
+	c := NewContainer()
+	var log []string
+	calls := 0
+
+	Register(c, "name", "demo")
+	Provide(c, "db", func(c *Container) (*testService, error) {
+		calls++
+		return &testService{name: "db", log: &log}, nil
+	})
+	Provide(c, "api", func(c *Container) (Stopper, error) {
+		if _, err := Resolve[*testService](c, "db"); err != nil {
+			return nil, err
+		}
+		return &testService{name: "api", log: &log}, nil
+	})
+
+	if calls != 0 {
+		t.Fatal("provider called before use")
+	}
+	if name, err := Resolve[string](c, ""); err != nil || name != "demo" {
+		t.Errorf("by type: %q %v", name, err)
+	}
+	if _, err := Resolve[int](c, "name"); err == nil {
+		t.Error("wrong type resolved")
+	}
+	if _, err := Resolve[*testService](c, "nothere"); !errors.Is(err, ErrNoService) {
+		t.Errorf("missing service: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			MustResolve[Stopper](c, "api")
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("db created %d times", calls)
+	}
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := "start db,start api,stop api,stop db"
+	if got := strings.Join(log, ","); got != want {
+		t.Errorf("lifecycle %s, want %s", got, want)
+	}
+}
+
+func TestContainerCycle(t *testing.T) {
+	c := NewContainer()
+	Provide(c, "a", func(c *Container) (int, error) { return Resolve[int](c, "b") })
+	Provide(c, "b", func(c *Container) (int, error) { return Resolve[int](c, "a") })
+
+	if _, err := Resolve[int](c, "a"); !errors.Is(err, ErrServiceCycle) {
+		t.Errorf("cycle: %v", err)
+	}
+}
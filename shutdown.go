@@ -0,0 +1,193 @@
+package ebase
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// shutdown hook priorities, lower value runs first
+const (
+	ShutdownFirst   = 0
+	ShutdownDefault = 50
+	ShutdownLast    = 100
+)
+
+// process exit codes used by shutdown
+const (
+	ExitOk              = 0
+	ExitFailure         = 1 // startup failure or a hook returned error
+	ExitShutdownTimeout = 2 // hooks did not finish before the deadline
+	ExitShutdownForced  = 3 // second signal received while shutting down
+)
+
+// default overall shutdown deadline, sys.shutdown_timeout overrides it
+const DefaultShutdownTimeout = 30 * time.Second
+
+type ShutdownFunc func(ctx context.Context) error
+
+type shutdownHook struct {
+	name     string
+	priority int
+	seq      int
+	fn       ShutdownFunc
+}
+
+type ShutdownManager struct {
+	Timeout time.Duration
+
+	lock    sync.Mutex
+	hooks   []*shutdownHook
+	seq     int
+	running bool
+}
+
+// default shutdown manager used by ebase subsystems
+var Shutdowner = NewShutdownManager(DefaultShutdownTimeout)
+
+func NewShutdownManager(timeout time.Duration) *ShutdownManager {
+	return &ShutdownManager{Timeout: timeout}
+}
+
+// register a named shutdown hook, a hook with the same name is replaced
+func (m *ShutdownManager) Register(name string, priority int, fn ShutdownFunc) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i, h := range m.hooks {
+		if h.name == name {
+			m.hooks = append(m.hooks[:i], m.hooks[i+1:]...)
+			break
+		}
+	}
+
+	m.seq++
+	m.hooks = append(m.hooks, &shutdownHook{name: name, priority: priority, seq: m.seq, fn: fn})
+}
+
+// remove a shutdown hook by name
+func (m *ShutdownManager) Unregister(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i, h := range m.hooks {
+		if h.name == name {
+			m.hooks = append(m.hooks[:i], m.hooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// Running reports whether shutdown has started.
+func (m *ShutdownManager) Running() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.running
+}
+
+// mark shutdown as started, false if it already was
+func (m *ShutdownManager) begin() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.running {
+		return false
+	}
+	m.running = true
+	return true
+}
+
+// Run executes all hooks in priority order (registration order for equal
+// priority) under the overall Timeout and returns the exit code to use.
+// Hooks still running when the deadline passes are logged as overrun and
+// the remaining hooks are skipped.
+func (m *ShutdownManager) Run() int {
+	m.lock.Lock()
+	m.running = true
+	hooks := make([]*shutdownHook, len(m.hooks))
+	copy(hooks, m.hooks)
+	timeout := m.Timeout
+	m.lock.Unlock()
+
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].priority != hooks[j].priority {
+			return hooks[i].priority < hooks[j].priority
+		}
+		return hooks[i].seq < hooks[j].seq
+	})
+
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	code := ExitOk
+	for i, h := range hooks {
+		if ctx.Err() != nil {
+			for _, s := range hooks[i:] {
+				logInfof("shutdown hook %s skipped, deadline %s exceeded", s.name, timeout)
+			}
+			return ExitShutdownTimeout
+		}
+
+		start := time.Now()
+		done := make(chan error, 1)
+		go func(h *shutdownHook) {
+			defer func() {
+				if r := recover(); r != nil {
+					done <- fmt.Errorf("panic: %v", r)
+				}
+			}()
+			done <- h.fn(ctx)
+		}(h)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				logInfof("shutdown hook %s failed after %s: %s", h.name, time.Since(start), err)
+				code = ExitFailure
+			} else {
+				logInfof("shutdown hook %s done in %s", h.name, time.Since(start))
+			}
+		case <-ctx.Done():
+			logInfof("shutdown hook %s overran, still running after %s", h.name, time.Since(start))
+			code = ExitShutdownTimeout
+		}
+	}
+
+	return code
+}
+
+// register a hook on the default shutdown manager
+func RegisterShutdown(name string, priority int, fn ShutdownFunc) {
+	Shutdowner.Register(name, priority, fn)
+}
+
+// remove a hook from the default shutdown manager
+func UnregisterShutdown(name string) {
+	Shutdowner.Unregister(name)
+}
+
+// Shutdown runs the default shutdown hooks and exits the process. code is
+// used when every hook finished cleanly, otherwise the hook failure code wins.
+func Shutdown(code int) {
+	if !Shutdowner.begin() {
+		logInfof("shutdown already in progress, forced exit")
+		flushLog()
+		os.Exit(ExitShutdownForced)
+	}
+
+	sdNotify("STOPPING=1")
+	if ret := Shutdowner.Run(); ret != ExitOk {
+		code = ret
+	}
+
+	logInfof("shutdown complete, exit code %d", code)
+	flushLog()
+	os.Exit(code)
+}
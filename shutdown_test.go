@@ -0,0 +1,99 @@
+package ebase
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownOrder(t *testing.T) {
+	m := NewShutdownManager(time.Second)
+
+	var order []string
+	hook := func(name string) ShutdownFunc {
+		return func(ctx context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	m.Register("pid", ShutdownLast, hook("pid"))
+	m.Register("mail", ShutdownDefault, hook("mail"))
+	m.Register("http", ShutdownFirst, hook("http"))
+	m.Register("db", ShutdownDefault, hook("db"))
+
+	if code := m.Run(); code != ExitOk {
+		t.Fatalf("exit code %d, want %d", code, ExitOk)
+	}
+
+	want := []string{"http", "mail", "db", "pid"}
+	if len(order) != len(want) {
+		t.Fatalf("ran %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("ran %v, want %v", order, want)
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	m := NewShutdownManager(50 * time.Millisecond)
+
+	skipped := true
+	m.Register("slow", ShutdownFirst, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	m.Register("after", ShutdownLast, func(ctx context.Context) error {
+		skipped = false
+		return nil
+	})
+
+	if code := m.Run(); code != ExitShutdownTimeout {
+		t.Fatalf("exit code %d, want %d", code, ExitShutdownTimeout)
+	}
+	if !skipped {
+		t.Fatal("hook after deadline should be skipped")
+	}
+}
+
+func TestShutdownError(t *testing.T) {
+	m := NewShutdownManager(time.Second)
+	m.Register("bad", ShutdownDefault, func(ctx context.Context) error {
+		return errors.New("close failed")
+	})
+
+	if code := m.Run(); code != ExitFailure {
+		t.Fatalf("exit code %d, want %d", code, ExitFailure)
+	}
+}
+
+func TestShutdownBeginOnce(t *testing.T) {
+	m := NewShutdownManager(time.Second)
+
+	// two signals at once: exactly one runs the hooks, the other forces
+	var wg sync.WaitGroup
+	started := make(chan bool, 8)
+	for i := 0; i < cap(started); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started <- m.begin()
+		}()
+	}
+	wg.Wait()
+	close(started)
+
+	n := 0
+	for ok := range started {
+		if ok {
+			n++
+		}
+	}
+	if n != 1 || !m.Running() {
+		t.Fatalf("%d started, running %v", n, m.Running())
+	}
+}
@@ -0,0 +1,216 @@
+package ebase
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// signal handler, returned errors are written to Log
+type SignalFunc func(os.Signal) error
+
+type signalEntry struct {
+	id int
+	fn SignalFunc
+}
+
+type SignalRegistry struct {
+	lock     sync.RWMutex
+	handlers map[os.Signal][]signalEntry
+	seq      int
+	ch       chan os.Signal
+	serving  bool
+}
+
+// default signal registry, served by EbaseInit when sys.signal is enabled
+var Signals = NewSignalRegistry()
+
+// names of the signals accepted in the old SigHandler map
+var legacySignalNames = map[string]os.Signal{
+	"sighup":   syscall.SIGHUP,
+	"sigint":   syscall.SIGINT,
+	"sigterm":  syscall.SIGTERM,
+	"sigquit":  syscall.SIGQUIT,
+	"sigusr1":  syscall.SIGUSR1,
+	"sigusr2":  syscall.SIGUSR2,
+	"sigwinch": syscall.SIGWINCH,
+}
+
+func NewSignalRegistry() *SignalRegistry {
+	return &SignalRegistry{
+		handlers: make(map[os.Signal][]signalEntry),
+		ch:       make(chan os.Signal, 8),
+	}
+}
+
+// Handle registers fn for sig and returns an id for Unhandle. Handlers of
+// the same signal run in registration order.
+func (r *SignalRegistry) Handle(sig os.Signal, fn SignalFunc) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.seq++
+	r.handlers[sig] = append(r.handlers[sig], signalEntry{id: r.seq, fn: fn})
+	if r.serving {
+		signal.Notify(r.ch, sig)
+	}
+
+	return r.seq
+}
+
+// Unhandle removes the handler registered with id. When no handler is
+// left for sig the signal gets its default behavior back.
+func (r *SignalRegistry) Unhandle(sig os.Signal, id int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	list := r.handlers[sig]
+	for i, e := range list {
+		if e.id == id {
+			list = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+
+	if len(list) > 0 {
+		r.handlers[sig] = list
+		return
+	}
+
+	delete(r.handlers, sig)
+	if r.serving {
+		signal.Reset(sig)
+	}
+}
+
+// Reset removes every handler of sig
+func (r *SignalRegistry) Reset(sig os.Signal) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.handlers, sig)
+	if r.serving {
+		signal.Reset(sig)
+	}
+}
+
+
+// Serve dispatches incoming signals to the registered handlers until ctx
+// is done.
+func (r *SignalRegistry) Serve(ctx context.Context) {
+	r.lock.Lock()
+	if r.serving {
+		r.lock.Unlock()
+		return
+	}
+	r.serving = true
+	for sig := range r.handlers {
+		signal.Notify(r.ch, sig)
+	}
+	r.lock.Unlock()
+
+	defer func() {
+		r.lock.Lock()
+		r.serving = false
+		signal.Stop(r.ch)
+		r.lock.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-r.ch:
+			r.Dispatch(sig)
+		}
+	}
+}
+
+// Dispatch runs the handlers of sig
+func (r *SignalRegistry) Dispatch(sig os.Signal) {
+	r.lock.RLock()
+	list := make([]signalEntry, len(r.handlers[sig]))
+	copy(list, r.handlers[sig])
+	r.lock.RUnlock()
+
+	for _, e := range list {
+		if err := callSignalFunc(e.fn, sig); err != nil {
+			if Log != nil {
+				Log.Errorf("signal %s handler error: %s", sig, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "signal %s handler error: %s\n", sig, err)
+			}
+		}
+	}
+}
+
+func callSignalFunc(fn SignalFunc, sig os.Signal) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return fn(sig)
+}
+
+// register a handler on the default registry
+func HandleSignal(sig os.Signal, fn SignalFunc) int {
+	return Signals.Handle(sig, fn)
+}
+
+// remove a handler from the default registry
+func UnhandleSignal(sig os.Signal, id int) {
+	Signals.Unhandle(sig, id)
+}
+
+// signal handler that runs the shutdown hooks and exits
+func ShutdownSignal(sig os.Signal) error {
+	go Shutdown(ExitOk)
+	return nil
+}
+
+// HandleLegacySignals registers handlers for the entries of funcs by the
+// old names (sighup, sigint, sigterm, sigquit, sigusr1, sigusr2,
+// sigwinch). Signals without an entry keep their default behavior.
+func HandleLegacySignals(r *SignalRegistry, funcs map[string]interface{}) {
+	for name, sig := range legacySignalNames {
+		f, ok := funcs[name]
+		if !ok || f == nil {
+			continue
+		}
+
+		name := name
+		r.Handle(sig, func(s os.Signal) error {
+			switch ff := f.(type) {
+			case func():
+				ff()
+			case func() error:
+				return ff()
+			case func(os.Signal) error:
+				return ff(s)
+			case SignalFunc:
+				return ff(s)
+			default:
+				return fmt.Errorf("SigHandler[%q] has unsupported type %T", name, f)
+			}
+
+			return nil
+		})
+	}
+}
+
+// SignalHandle serves the old string keyed handler map, SIGINT and SIGTERM
+// run the shutdown hooks after the map handler. It blocks forever.
+//
+// Deprecated: use HandleSignal and Signals.Serve.
+func SignalHandle(funcs map[string]interface{}) {
+	HandleLegacySignals(Signals, funcs)
+	Signals.Handle(syscall.SIGINT, ShutdownSignal)
+	Signals.Handle(syscall.SIGTERM, ShutdownSignal)
+
+	Signals.Serve(context.Background())
+}
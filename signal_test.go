@@ -0,0 +1,67 @@
+package ebase
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalRegistry(t *testing.T) {
+	r := NewSignalRegistry()
+	got := make(chan string, 4)
+
+	r.Handle(syscall.SIGUSR1, func(sig os.Signal) error {
+		got <- "first"
+		return nil
+	})
+	id := r.Handle(syscall.SIGUSR1, func(sig os.Signal) error {
+		got <- "second"
+		return nil
+	})
+
+	legacy := map[string]interface{}{"sigusr1": func() { got <- "legacy" }, "sigquit": nil}
+	HandleLegacySignals(r, legacy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Serve(ctx)
+
+	expect := func(want ...string) {
+		for _, w := range want {
+			select {
+			case s := <-got:
+				if s != w {
+					t.Fatalf("got handler %s, want %s", s, w)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("handler %s not called", w)
+			}
+		}
+	}
+
+	// wait until Serve has subscribed
+	time.Sleep(50 * time.Millisecond)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	expect("first", "second", "legacy")
+
+	r.Unhandle(syscall.SIGUSR1, id)
+	r.Dispatch(syscall.SIGUSR1)
+	expect("first", "legacy")
+
+	// dropping the last handler of another signal leaves SIGUSR1 subscribed
+	winch := r.Handle(syscall.SIGWINCH, func(sig os.Signal) error { return nil })
+	r.Unhandle(syscall.SIGWINCH, winch)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	expect("first", "legacy")
+}
+
+func TestLegacySignalsDefault(t *testing.T) {
+	r := NewSignalRegistry()
+	HandleLegacySignals(r, map[string]interface{}{"sighup": func() {}, "sigquit": nil})
+
+	if len(r.handlers) != 1 || len(r.handlers[syscall.SIGHUP]) != 1 {
+		t.Errorf("handlers %v, want only SIGHUP", r.handlers)
+	}
+}
@@ -0,0 +1,435 @@
+package ebase
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// environment of a worker started by Supervise: its marker, and the fd of
+// its control socket to the master over which Restart hands the listeners
+// to a new worker
+const (
+	workerEnv     = "EBASE_WORKER"
+	supervisorEnv = "EBASE_SUPERVISOR"
+)
+
+var (
+	// sys.supervise is set but EbaseInit ran attached to a terminal, Run
+	// supervises
+	supervisePending bool
+
+	// the control socket of a worker, nil in any other process
+	supervisorConn *net.UnixConn
+)
+
+func init() {
+	if v := os.Getenv(supervisorEnv); v != "" {
+		os.Unsetenv(supervisorEnv)
+		if fd, err := strconv.Atoi(v); err == nil {
+			f := os.NewFile(uintptr(fd), "supervisor")
+			if c, err := net.FileConn(f); err == nil {
+				supervisorConn, _ = c.(*net.UnixConn)
+			}
+			f.Close()
+		}
+	}
+}
+
+type SuperviseOptions struct {
+	MinBackoff  time.Duration // first restart delay
+	MaxBackoff  time.Duration // restart delay cap, doubled on each crash
+	StableTime  time.Duration // a worker running this long resets the delay
+	MaxRestarts int           // restarts allowed within Window, 0 unlimited
+	Window      time.Duration
+}
+
+// IsWorker reports whether this process is a worker started by Supervise
+func IsWorker() bool {
+	return os.Getenv(workerEnv) == "1"
+}
+
+// supervise options from sys.restart_max, sys.restart_window and
+// sys.restart_backoff (seconds)
+func DefaultSuperviseOptions() *SuperviseOptions {
+	opt := &SuperviseOptions{
+		MinBackoff:  time.Second,
+		MaxBackoff:  time.Minute,
+		StableTime:  30 * time.Second,
+		MaxRestarts: 10,
+		Window:      5 * time.Minute,
+	}
+	if Config == nil {
+		return opt
+	}
+
+	opt.MaxRestarts, _ = Config.Int("sys.restart_max", opt.MaxRestarts)
+	if w, _ := Config.Int("sys.restart_window", 0); w > 0 {
+		opt.Window = time.Duration(w) * time.Second
+	}
+	if b, _ := Config.Int("sys.restart_backoff", 0); b > 0 {
+		opt.MaxBackoff = time.Duration(b) * time.Second
+	}
+
+	return opt
+}
+
+// Supervise turns the process into a master that runs the same binary as
+// a worker and restarts it with exponential backoff when it exits
+// abnormally. SIGHUP, SIGUSR1 and SIGUSR2 are forwarded to the worker,
+// SIGTERM and SIGINT are forwarded and stop the master once the worker
+// exits. The master reports ready when the first worker does, a daemon
+// master exits when the first worker fails to start. A Restart in the
+// worker hands its listeners to the master, which starts and supervises
+// the new worker. In the master it does not return; in the worker it
+// returns at once.
+func Supervise(opt *SuperviseOptions) {
+	if IsWorker() {
+		return
+	}
+	if opt == nil {
+		opt = DefaultSuperviseOptions()
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		superviseExit(ExitFailure, "supervisor: %s", err)
+	}
+
+	// the worker rotates the log files the master shares
+	if Log != nil {
+		Log.followFiles()
+	}
+
+	// the handlers registered by EbaseInit belong to the worker, the master
+	// only forwards
+	signal.Reset()
+	sv := &supervisor{opt: opt, sigs: make(chan os.Signal, 4),
+		command: func() *exec.Cmd { return exec.Command(exe, os.Args[1:]...) }}
+	signal.Notify(sv.sigs, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT,
+		syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	code, msg := sv.run()
+	superviseExit(code, "%s", msg)
+}
+
+// the master loop of Supervise
+type supervisor struct {
+	opt     *SuperviseOptions
+	sigs    chan os.Signal
+	command func() *exec.Cmd // a new worker command
+}
+
+// a running worker of the master
+type worker struct {
+	cmd    *exec.Cmd
+	start  time.Time
+	exited chan error
+	status <-chan string // startup status, nil without a status pipe
+	ctl    *net.UnixConn // master end of the control socket
+	reqs   chan *handover
+}
+
+// a Restart of the worker asking for a new worker with its listeners
+type handover struct {
+	names   []string
+	files   []*os.File
+	timeout time.Duration
+}
+
+// start a worker inheriting the listener files, with a status pipe when
+// status is set. A worker that failed to start has exited already.
+func (sv *supervisor) startWorker(names []string, files []*os.File, status bool) *worker {
+	wk := &worker{cmd: sv.command(), start: time.Now(), exited: make(chan error, 1),
+		reqs: make(chan *handover)}
+	cmd := wk.cmd
+	cmd.Env = append(os.Environ(), workerEnv+"=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(cmd.ExtraFiles, files...)
+	if len(names) > 0 {
+		cmd.Env = append(cmd.Env, listenFdsEnv+"="+strings.Join(names, ","))
+	}
+
+	var child []*os.File
+	defer func() { closeFiles(child) }()
+	if status {
+		if r, w, err := os.Pipe(); err == nil {
+			cmd.Env = append(cmd.Env, daemonEnv+"="+GetIntStr(3+len(cmd.ExtraFiles)))
+			cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+			child = append(child, w)
+			wk.status = readStatus(r)
+		}
+	}
+	if f, ctl, err := controlSocket(); err == nil {
+		cmd.Env = append(cmd.Env, supervisorEnv+"="+GetIntStr(3+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+		child = append(child, f)
+		wk.ctl = ctl
+	}
+
+	if err := cmd.Start(); err != nil {
+		wk.exited <- err
+		wk.closeControl()
+		return wk
+	}
+	logInfof("supervisor: worker pid %d started", cmd.Process.Pid)
+	go func() { wk.exited <- cmd.Wait() }()
+	if wk.ctl != nil {
+		go wk.readControl()
+	}
+	return wk
+}
+
+// a connected unix socket pair, the file for the worker and the master end
+func controlSocket() (*os.File, *net.UnixConn, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+
+	f := os.NewFile(uintptr(fds[0]), "supervisor-master")
+	defer f.Close()
+	c, err := net.FileConn(f)
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+	return os.NewFile(uintptr(fds[1]), "supervisor"), c.(*net.UnixConn), nil
+}
+
+// pass the handover requests of the worker to reqs until it closes the
+// control socket: "RESTART <timeout> <names>" with the listener fds
+func (wk *worker) readControl() {
+	defer close(wk.reqs)
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(64*4))
+	for {
+		n, oobn, _, _, err := wk.ctl.ReadMsgUnix(buf, oob)
+		if err != nil || n == 0 {
+			return
+		}
+
+		var files []*os.File
+		if msgs, err := syscall.ParseSocketControlMessage(oob[:oobn]); err == nil {
+			for _, m := range msgs {
+				fds, _ := syscall.ParseUnixRights(&m)
+				for _, fd := range fds {
+					syscall.CloseOnExec(fd)
+					files = append(files, os.NewFile(uintptr(fd), "listener"))
+				}
+			}
+		}
+
+		h := &handover{files: files}
+		fields := strings.Fields(string(buf[:n]))
+		if len(fields) > 1 && fields[0] == "RESTART" {
+			t, _ := strconv.ParseInt(fields[1], 10, 64)
+			h.timeout = time.Duration(t)
+		}
+		if len(fields) > 2 {
+			h.names = strings.Split(fields[2], ",")
+		}
+		if len(h.names) != len(h.files) {
+			closeFiles(files)
+			wk.reply("ERR %d listeners for %d fds", len(h.names), len(h.files))
+			continue
+		}
+		wk.reqs <- h
+	}
+}
+
+func (wk *worker) reply(format string, v ...interface{}) {
+	wk.ctl.Write([]byte(fmt.Sprintf(format, v...) + "\n"))
+}
+
+func (wk *worker) closeControl() {
+	if wk.ctl != nil {
+		wk.ctl.Close()
+	}
+}
+
+// start the worker taking over from wk and wait until it is ready
+func (sv *supervisor) handover(h *handover) (*worker, error) {
+	defer closeFiles(h.files)
+
+	next := sv.startWorker(h.names, h.files, true)
+	if h.timeout <= 0 {
+		h.timeout = 30 * time.Second
+	}
+
+	var err error
+	select {
+	case s := <-next.status:
+		if s == "OK" {
+			next.status = nil
+			return next, nil
+		}
+		if s != "" {
+			err = fmt.Errorf("new worker failed: %s", strings.TrimPrefix(s, "ERR "))
+		}
+	case <-time.After(h.timeout):
+		err = fmt.Errorf("new worker not ready after %s", h.timeout)
+	}
+
+	if next.cmd.Process != nil {
+		next.cmd.Process.Kill()
+	}
+	if e := <-next.exited; err == nil {
+		err = fmt.Errorf("new worker exited: %v", e)
+	}
+	next.closeControl()
+	return nil, err
+}
+
+// run workers until the master should exit, returns the exit code and the
+// reason
+func (sv *supervisor) run() (int, string) {
+	opt := sv.opt
+	var (
+		crashes  int
+		restarts []time.Time
+		backoff  = opt.MinBackoff
+		stopping bool
+		ready    bool
+		err      error
+	)
+
+	for {
+		// until a worker is ready it gets a status pipe like a daemon child
+		wk := sv.startWorker(nil, nil, !ready)
+
+	wait:
+		for {
+			select {
+			case s := <-wk.status:
+				wk.status = nil
+				ready = relayStatus(s)
+			case h, ok := <-wk.reqs:
+				if !ok {
+					wk.reqs = nil
+					break
+				}
+				next, err := sv.handover(h)
+				if err != nil {
+					logInfof("supervisor: restart of worker pid %d failed: %s", wk.cmd.Process.Pid, err)
+					wk.reply("ERR %s", err)
+					break
+				}
+				// the old worker drains and exits on its own
+				logInfof("supervisor: worker pid %d replaced by pid %d", wk.cmd.Process.Pid, next.cmd.Process.Pid)
+				wk.reply("OK %d", next.cmd.Process.Pid)
+				wk.closeControl()
+				wk, ready = next, true
+			case sig := <-sv.sigs:
+				if isStopSignal(sig) {
+					stopping = true
+				}
+				if wk.cmd.Process != nil {
+					wk.cmd.Process.Signal(sig)
+				}
+			case err = <-wk.exited:
+				break wait
+			}
+		}
+		wk.closeControl()
+		if wk.status != nil {
+			// the pipe is closed now, a status written before exit is in it
+			ready = relayStatus(<-wk.status)
+		}
+
+		cmd := wk.cmd
+		status := "start failed: " + fmt.Sprint(err)
+		if cmd.ProcessState != nil {
+			status = cmd.ProcessState.String()
+		}
+
+		if stopping {
+			code := ExitOk
+			if cmd.ProcessState != nil && cmd.ProcessState.ExitCode() > 0 {
+				code = cmd.ProcessState.ExitCode()
+			}
+			return code, fmt.Sprintf("supervisor: worker stopped (%s), exit", status)
+		}
+
+		if err == nil {
+			return ExitOk, "supervisor: worker exited normally, exit"
+		}
+
+		if !ready && IsDaemonChild() {
+			DaemonFailed(fmt.Errorf("worker exited during startup: %s", status))
+			return ExitFailure, fmt.Sprintf("supervisor: worker failed to start (%s), exit", status)
+		}
+
+		crashes++
+		logInfof("supervisor: worker exited abnormally (%s) after %s, crash count %d",
+			status, time.Since(wk.start).Round(time.Millisecond), crashes)
+
+		now := time.Now()
+		if opt.MaxRestarts > 0 {
+			recent := restarts[:0]
+			for _, t := range restarts {
+				if now.Sub(t) < opt.Window {
+					recent = append(recent, t)
+				}
+			}
+			restarts = recent
+			if len(restarts) >= opt.MaxRestarts {
+				return ExitFailure, fmt.Sprintf("supervisor: %d restarts within %s, giving up, last exit %s",
+					len(restarts), opt.Window, status)
+			}
+		}
+		restarts = append(restarts, now)
+
+		if time.Since(wk.start) >= opt.StableTime {
+			backoff = opt.MinBackoff
+		}
+
+		logInfof("supervisor: restarting worker in %s", backoff)
+		select {
+		case sig := <-sv.sigs:
+			if isStopSignal(sig) {
+				return ExitOk, "supervisor: stopped while waiting to restart"
+			}
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > opt.MaxBackoff {
+			backoff = opt.MaxBackoff
+		}
+	}
+}
+
+func isStopSignal(sig os.Signal) bool {
+	return sig == syscall.SIGTERM || sig == syscall.SIGINT || sig == syscall.SIGQUIT
+}
+
+// pass the startup status of a worker on, true when it is ready
+func relayStatus(s string) bool {
+	if s == "OK" {
+		DaemonReady()
+		return true
+	}
+	if s != "" {
+		// the worker's reason, the worker exits next
+		daemonReport(s)
+	}
+	return false
+}
+
+// run the master's shutdown hooks, pid file removal among them, and exit
+func superviseExit(code int, format string, v ...interface{}) {
+	logInfof(format, v...)
+	if ret := Shutdowner.Run(); ret != ExitOk && code == ExitOk {
+		code = ret
+	}
+	flushLog()
+	os.Exit(code)
+}
@@ -0,0 +1,188 @@
+package ebase
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// the test binary run as a worker by TestSuperviseRestart: the first
+// worker restarts, the new one takes its listener over and exits. Each
+// notes its listen address and pid in the EBASE_TEST_WORKER dir.
+func init() {
+	dir := os.Getenv("EBASE_TEST_WORKER")
+	if dir == "" || !IsWorker() {
+		return
+	}
+
+	_, handed := inherited["web"]
+	l, err := Listen("web", "tcp", "127.0.0.1:0")
+	if err != nil {
+		DaemonFailed(err)
+		os.Exit(ExitFailure)
+	}
+	Ready()
+
+	note := func(name, s string) { os.WriteFile(dir+"/"+name, []byte(s), 0644) }
+	if handed {
+		note("new", l.Addr().String()+" "+strconv.Itoa(os.Getpid()))
+		os.Exit(ExitOk)
+	}
+
+	note("old", l.Addr().String())
+	p, err := Restart(10 * time.Second)
+	if err != nil {
+		note("err", err.Error())
+		os.Exit(ExitFailure)
+	}
+	note("restarted", strconv.Itoa(p.Pid))
+	os.Exit(ExitOk)
+}
+
+// a supervisor running sh -c script as its worker, logging to the buffer
+func testSupervisor(t *testing.T, script string, opt *SuperviseOptions) (*supervisor, func() string) {
+	l, buf := testLog(LogFormatText, 0)
+	old := Log
+	Log = l
+	t.Cleanup(func() { Log = old })
+
+	sv := &supervisor{opt: opt, sigs: make(chan os.Signal, 4),
+		command: func() *exec.Cmd { return exec.Command("sh", "-c", script) }}
+	return sv, buf.String
+}
+
+func TestSuperviseBackoff(t *testing.T) {
+	sv, logged := testSupervisor(t, "exit 2", &SuperviseOptions{MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 40 * time.Millisecond, StableTime: time.Hour, MaxRestarts: 4, Window: time.Minute})
+
+	code, msg := sv.run()
+	if code != ExitFailure || !strings.Contains(msg, "4 restarts within 1m0s") {
+		t.Errorf("exit %d %q", code, msg)
+	}
+
+	var delays []string
+	for _, m := range regexp.MustCompile(`restarting worker in (\S+)`).FindAllStringSubmatch(logged(), -1) {
+		delays = append(delays, m[1])
+	}
+	// doubled on each crash up to MaxBackoff
+	if got := strings.Join(delays, " "); got != "10ms 20ms 40ms 40ms" {
+		t.Errorf("delays %q", got)
+	}
+	if n := strings.Count(logged(), "exit status 2"); n != 5 {
+		t.Errorf("%d crashes logged", n)
+	}
+}
+
+func TestSuperviseStableReset(t *testing.T) {
+	sv, logged := testSupervisor(t, "exit 1", &SuperviseOptions{MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: time.Second, MaxRestarts: 3, Window: time.Minute})
+
+	if code, _ := sv.run(); code != ExitFailure {
+		t.Errorf("exit %d", code)
+	}
+	// with no StableTime every worker counts as stable
+	if n := strings.Count(logged(), "restarting worker in 10ms"); n != 3 {
+		t.Errorf("%d minimum delays in %q", n, logged())
+	}
+}
+
+func TestSuperviseNormalExit(t *testing.T) {
+	sv, _ := testSupervisor(t, "exit 0", DefaultSuperviseOptions())
+	if code, msg := sv.run(); code != ExitOk || !strings.Contains(msg, "exited normally") {
+		t.Errorf("exit %d %q", code, msg)
+	}
+}
+
+func TestSuperviseForward(t *testing.T) {
+	// the worker reports each forwarded signal and exits on TERM
+	dir := t.TempDir()
+	sv, _ := testSupervisor(t, `trap 'echo hup >> `+dir+`/sigs' HUP
+trap 'echo term >> `+dir+`/sigs; exit 3' TERM
+echo up > `+dir+`/up
+while :; do sleep 0.01; done`, DefaultSuperviseOptions())
+
+	go func() {
+		for i := 0; i < 500; i++ {
+			if _, err := os.Stat(dir + "/up"); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		sv.sigs <- syscall.SIGHUP
+		time.Sleep(100 * time.Millisecond)
+		sv.sigs <- syscall.SIGTERM
+	}()
+
+	done := make(chan struct{})
+	var code int
+	var msg string
+	go func() {
+		code, msg = sv.run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("supervisor did not stop")
+	}
+
+	// a stopping master exits with the worker's code
+	if code != 3 || !strings.Contains(msg, "worker stopped") {
+		t.Errorf("exit %d %q", code, msg)
+	}
+	sigs, _ := os.ReadFile(dir + "/sigs")
+	if string(sigs) != "hup\nterm\n" {
+		t.Errorf("worker got %q", sigs)
+	}
+}
+
+func TestSuperviseStopWhileWaiting(t *testing.T) {
+	sv, _ := testSupervisor(t, "exit 4", &SuperviseOptions{MinBackoff: time.Hour, MaxBackoff: time.Hour})
+	sv.sigs <- syscall.SIGINT
+	// the signal arrives while the worker runs or while the master waits,
+	// either way the master stops without a restart
+	if code, msg := sv.run(); code == ExitFailure || !strings.Contains(msg, "stop") {
+		t.Errorf("exit %d %q", code, msg)
+	}
+}
+
+func TestSuperviseRestart(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("EBASE_TEST_WORKER", dir)
+	l, buf := testLog(LogFormatText, 0)
+	old := Log
+	Log = l
+	defer func() { Log = old }()
+
+	sv := &supervisor{opt: DefaultSuperviseOptions(), sigs: make(chan os.Signal, 4),
+		command: func() *exec.Cmd { return exec.Command(os.Args[0], "-test.run=^$") }}
+
+	// the master keeps the new worker, whose exit ends the run
+	code, msg := sv.run()
+	if code != ExitOk || !strings.Contains(msg, "exited normally") {
+		t.Errorf("exit %d %q", code, msg)
+	}
+
+	read := func(name string) string {
+		b, _ := os.ReadFile(dir + "/" + name)
+		return string(b)
+	}
+	if e := read("err"); e != "" {
+		t.Fatal(e)
+	}
+	addr, pid, _ := strings.Cut(read("new"), " ")
+	if addr == "" || addr != read("old") {
+		t.Errorf("new worker listens on %q, old on %q", addr, read("old"))
+	}
+	if pid != read("restarted") {
+		t.Errorf("new worker pid %s, Restart returned %s", pid, read("restarted"))
+	}
+	if !strings.Contains(buf.String(), "replaced by pid "+pid) {
+		t.Errorf("log %q", buf.String())
+	}
+}
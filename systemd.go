@@ -0,0 +1,132 @@
+package ebase
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// first fd passed by systemd socket activation
+const sdListenFdsStart = 3
+
+// SdNotify sends a state such as "READY=1" or "STATUS=..." to the socket
+// in NOTIFY_SOCKET. It returns false with no error when not running under
+// a notify unit.
+func SdNotify(state string) (bool, error) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return false, nil
+	}
+
+	// abstract socket
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// notify systemd, errors go to Log
+func sdNotify(state string) {
+	if _, err := SdNotify(state); err != nil && Log != nil {
+		Log.Errorf("sd_notify %q error: %s", state, err)
+	}
+}
+
+// SdStatus sets the free form unit status shown by systemctl status
+func SdStatus(status string) {
+	sdNotify("STATUS=" + status)
+}
+
+// SdWatchdogInterval returns the keep-alive period derived from
+// WATCHDOG_USEC, half of the configured timeout, or 0 if the watchdog is
+// off or meant for another process.
+func SdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != GetIntStr(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// SdWatchdog sends WATCHDOG=1 every SdWatchdogInterval until ctx is done.
+// It returns at once when the watchdog is not enabled.
+func SdWatchdog(ctx context.Context) {
+	interval := SdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
+
+// SdListeners returns the sockets passed by systemd socket activation
+// keyed by FileDescriptorName, unnamed sockets are keyed by their fd. The
+// environment is cleared so children don't inherit it.
+func SdListeners() (map[string]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if os.Getenv("LISTEN_PID") != GetIntStr(os.Getpid()) {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	ret := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		fd := sdListenFdsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := GetIntStr(fd)
+		if i < len(names) && names[i] != "" && names[i] != "unknown" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		ret[name] = l
+	}
+
+	return ret, nil
+}
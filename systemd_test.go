@@ -0,0 +1,51 @@
+package ebase
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	os.Unsetenv("NOTIFY_SOCKET")
+	if sent, err := SdNotify("READY=1"); sent || err != nil {
+		t.Fatalf("without NOTIFY_SOCKET sent %v err %v", sent, err)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", addr)
+	if sent, err := SdNotify("READY=1"); !sent || err != nil {
+		t.Fatalf("sent %v err %v", sent, err)
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "READY=1" {
+		t.Fatalf("got %q", buf[:n])
+	}
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "4000000")
+	t.Setenv("WATCHDOG_PID", GetIntStr(os.Getpid()))
+	if d := SdWatchdogInterval(); d != 2*time.Second {
+		t.Fatalf("interval %s, want 2s", d)
+	}
+
+	t.Setenv("WATCHDOG_PID", "1")
+	if d := SdWatchdogInterval(); d != 0 {
+		t.Fatalf("watchdog for another pid: interval %s", d)
+	}
+}